@@ -8,4 +8,6 @@ require (
 	github.com/gorilla/websocket v1.5.3 // indirect
 	github.com/joho/godotenv v1.5.1 // indirect
 	github.com/nedpals/supabase-go v0.5.0 // indirect
+	golang.org/x/sync v0.9.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1 // indirect
 )
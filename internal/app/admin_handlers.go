@@ -38,10 +38,11 @@ type AdminEventRow struct {
 }
 
 type AdminTaskProgressRow struct {
-	UserID    string `json:"user_id"`
-	TaskID    string `json:"task_id"`
-	HighScore int    `json:"high_score"`
-	IsCleared bool   `json:"is_cleared"`
+	UserID       string           `json:"user_id"`
+	TaskID       string           `json:"task_id"`
+	HighScore    int              `json:"high_score"`
+	IsCleared    bool             `json:"is_cleared"`
+	RubricScores []CriterionScore `json:"rubric_scores"`
 }
 
 type AdminExperimentDataset struct {
@@ -70,6 +71,8 @@ type adminTaskProgressUpdateRequest struct {
 	TaskID    string `json:"task_id"`
 	HighScore int    `json:"high_score"`
 	IsCleared bool   `json:"is_cleared"`
+	Reason    string `json:"reason"`
+	ChangedBy string `json:"changed_by"`
 }
 
 type adminDeleteUserRequest struct {
@@ -230,7 +233,7 @@ func adminTaskProgressHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	var rows []AdminTaskProgressRow
-	builder := supabaseClient.DB.From("task_progress").Select("user_id,task_id,high_score,is_cleared").OrderBy("task_id", "asc")
+	builder := supabaseClient.DB.From("task_progress").Select("user_id,task_id,high_score,is_cleared,rubric_scores").OrderBy("task_id", "asc")
 	if userID != "" {
 		builder.Eq("user_id", userID)
 	}
@@ -339,17 +342,23 @@ func adminTaskProgressUpdateHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	updateData := map[string]interface{}{
-		"high_score": req.HighScore,
-		"is_cleared": req.IsCleared,
-	}
-	var result []AdminTaskProgressRow
-	if err := supabaseClient.DB.From("task_progress").Update(updateData).Eq("user_id", userID).Eq("task_id", taskID).Execute(&result); err != nil {
+	// 変更は grade_overrides に記録される
+	record, _, err := adminSetTaskProgress(GradeChange{
+		UserID:    userID,
+		TaskID:    taskID,
+		Score:     req.HighScore,
+		IsCleared: req.IsCleared,
+		Kind:      GradeChangeManualUpdate,
+		Reason:    strings.TrimSpace(req.Reason),
+		ChangedBy: strings.TrimSpace(req.ChangedBy),
+	})
+	if err != nil {
 		log.Printf("ERROR: admin task_progress update failed: user_id=%s task_id=%s err=%v", userID, taskID, err)
 		writeJSONError(w, http.StatusInternalServerError, "Failed to update task progress")
 		return
 	}
-	writeJSON(w, map[string]interface{}{"status": "success", "task_progress": result})
+	result := []AdminTaskProgressRow{{UserID: userID, TaskID: taskID, HighScore: record.NewScore, IsCleared: record.IsCleared}}
+	writeJSON(w, map[string]interface{}{"status": "success", "task_progress": result, "override": record})
 }
 
 func adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
@@ -385,6 +394,21 @@ func adminDeleteUserHandler(w http.ResponseWriter, r *http.Request) {
 		writeJSONError(w, http.StatusInternalServerError, "Failed at step: task_progress")
 		return
 	}
+	if err := deleteByFilter("grade_overrides", "user_id", userID); err != nil {
+		log.Printf("ERROR: delete user failed at grade_overrides: user_id=%s err=%v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed at step: grade_overrides")
+		return
+	}
+	if err := deleteByFilter("grade_attempts", "user_id", userID); err != nil {
+		log.Printf("ERROR: delete user failed at grade_attempts: user_id=%s err=%v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed at step: grade_attempts")
+		return
+	}
+	if err := deleteByFilter("chat_sessions", "user_id", userID); err != nil {
+		log.Printf("ERROR: delete user failed at chat_sessions: user_id=%s err=%v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed at step: chat_sessions")
+		return
+	}
 	if err := deleteByFilter("experiment_events", "user_id", userID); err != nil {
 		log.Printf("ERROR: delete user failed at experiment_events user_id: user_id=%s err=%v", userID, err)
 		writeJSONError(w, http.StatusInternalServerError, "Failed at step: experiment_events by user_id")
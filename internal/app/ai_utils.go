@@ -1,13 +1,8 @@
 package app
 
 import (
-	"bytes"
 	"context"
-	"encoding/json"
-	"fmt"
-	"io"
 	"log"
-	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
@@ -153,78 +148,33 @@ func loadSummarySystemPrompt() {
 	}
 }
 
+// loadChatHistorySummarySystemPrompt は .txt から会話履歴の要約用プロンプトを読み込みます
+func loadChatHistorySummarySystemPrompt() {
+	content, err := os.ReadFile("./prompts/system/prompt_chat_summary.txt")
+	if err != nil {
+		log.Println("警告: prompt_chat_summary.txtの読み込み失敗。デフォルトを使用。")
+		chatHistorySummarySystemPrompt = "あなたは会話の要約係です。これまでの要約と古い会話を統合した要約を JSON の summary に出力してください。"
+	} else {
+		chatHistorySummarySystemPrompt = string(content)
+	}
+}
+
 //================================================================
 // ヘルパー関数
 //================================================================
 
-// callOpenAI は OpenAI API にリクエストを送り、結果の文字列を返します
-func callOpenAI(sysPrompt, userMsg string, useJSON bool) (string, error) {
-	apiKey := os.Getenv("OPENAI_API_KEY")
-	if apiKey == "" {
-		return "", fmt.Errorf("OPENAI_API_KEY が設定されていません")
-	}
-
-	reqMessages := []OpenAIMessage{
-		{Role: "system", Content: sysPrompt},
-		{Role: "user", Content: userMsg},
-	}
-
-	model := os.Getenv("OPENAI_MODEL")
-	if model == "" {
-		model = "gpt-4o-mini" // デフォルト
-	}
-
-	reqBody := OpenAIRequest{
-		Model:    model,
-		Messages: reqMessages,
-	}
-
-	// JSONモードの切り替えスイッチ
-	if useJSON {
-		reqBody.ResponseFormat = &ResponseFormat{Type: "json_object"}
-	}
-
-	reqBytes, err := json.Marshal(reqBody)
+// generateJSON は用途ごとのプロバイダでスキーマ付きの JSON 補完を行い、検証済みの結果を out にデコードします
+func generateJSON(purpose, sysPrompt, userMsg string, schema *OutputSchema, out any) error {
+	provider, err := getAIProvider(purpose)
 	if err != nil {
-		return "", fmt.Errorf("JSON作成エラー: %v", err)
+		return err
 	}
 
-	// ... (HTTPリクエスト作成部分は変更なし) ...
-	// req, err := http.NewRequestWithContext(...) など
-	// req.Header.Set(...) など
-
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	req, err := http.NewRequestWithContext(ctx, "POST", "https://api.openai.com/v1/chat/completions", bytes.NewBuffer(reqBytes))
-	if err != nil {
-		return "", fmt.Errorf("リクエスト作成エラー: %v", err)
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "Bearer "+apiKey)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", fmt.Errorf("API通信エラー: %v", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK {
-		bodyBytes, _ := io.ReadAll(resp.Body)
-		return "", fmt.Errorf("APIエラー (Status: %d): %s", resp.StatusCode, string(bodyBytes))
-	}
-
-	var openAIResp OpenAIResponse
-	if err := json.NewDecoder(resp.Body).Decode(&openAIResp); err != nil {
-		return "", fmt.Errorf("レスポンスデコードエラー: %v", err)
-	}
-
-	if len(openAIResp.Choices) == 0 || openAIResp.Choices[0].Message.Content == "" {
-		return "", fmt.Errorf("AIからの応答が空です")
-	}
-
-	return openAIResp.Choices[0].Message.Content, nil
+	_, err = generateStructured(ctx, provider, sysPrompt, []OpenAIMessage{{Role: "user", Content: userMsg}}, schema, out)
+	return err
 }
 
 // cleanJSONString は AIが返したマークダウン記法 (```json ... ```) を除去します
@@ -45,7 +45,7 @@ type AnthropicStreamEvent struct {
 	} `json:"error"`
 }
 
-func (p *AnthropicChatProvider) GenerateChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage) (string, error) {
+func (p *AnthropicChatProvider) generate(ctx context.Context, systemPrompt string, messages []OpenAIMessage) (string, error) {
 	reqBody := AnthropicRequest{
 		Model:     p.Model,
 		MaxTokens: p.MaxTokens,
@@ -89,11 +89,27 @@ func (p *AnthropicChatProvider) GenerateChat(ctx context.Context, systemPrompt s
 	return out.String(), nil
 }
 
-func (p *AnthropicChatProvider) StreamChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage, onDelta func(string) error) (string, error) {
+// anthropicJSONInstruction は JSON モードが無い Anthropic で、JSON 以外を出力させないための指示
+const anthropicJSONInstruction = "\n\nRespond with a single JSON object only. Do not wrap it in markdown code fences or add any text before or after it."
+
+// anthropicJSONSystemPrompt は JSON のみを返す指示と、スキーマがあればその内容をシステムプロンプトに追記します
+func anthropicJSONSystemPrompt(systemPrompt string, schema *OutputSchema) string {
+	systemPrompt += anthropicJSONInstruction
+	if schema != nil {
+		systemPrompt += " The object must match this JSON Schema:\n" + string(schema.Raw)
+	}
+	return systemPrompt
+}
+
+func (p *AnthropicChatProvider) GenerateJSON(ctx context.Context, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema) (string, error) {
+	return p.generate(ctx, anthropicJSONSystemPrompt(systemPrompt, schema), messages)
+}
+
+func (p *AnthropicChatProvider) StreamChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema, onDelta func(string) error) (string, error) {
 	reqBody := AnthropicRequest{
 		Model:     p.Model,
 		MaxTokens: p.MaxTokens,
-		System:    systemPrompt,
+		System:    anthropicJSONSystemPrompt(systemPrompt, schema),
 		Messages:  anthropicMessages(messages),
 		Stream:    true,
 	}
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"fmt"
+	"hash/fnv"
+	"log"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// 要約（これまでの会話のあらすじ）の最大文字数
+const maxChatSummaryRunes = 1200
+
+// メッセージ1件ごとに役割名や区切りで加算されるトークン数の目安
+const chatMessageTokenOverhead = 4
+
+// ChatHistory は AI に渡す会話の履歴。古いやり取りは Summary にまとめ、最近のやり取りだけを Messages にそのまま残す
+type ChatHistory struct {
+	Summary         string
+	SummarizedUntil int64 // Summary に含めた最後のメッセージの ID
+	Messages        []ChatSessionMessage
+}
+
+// chatContextTokens は1回のチャットで AI に渡す入力（システムプロンプト・履歴・今回の発言）の上限トークン数
+func chatContextTokens() int {
+	return positiveIntEnv("CHAT_CONTEXT_TOKENS", 8000)
+}
+
+// chatHistoryTokens は要約せずに残す履歴のトークン数。これを超えたら古いやり取りを要約にまとめる
+func chatHistoryTokens() int {
+	return positiveIntEnv("CHAT_HISTORY_TOKENS", 2000)
+}
+
+// chatRecentMessages はトークン数にかかわらず要約せずに残す直近のメッセージ数
+func chatRecentMessages() int {
+	return positiveIntEnv("CHAT_RECENT_MESSAGES", 6)
+}
+
+// estimateTokens はプロバイダのトークナイザに合わせてテキストのトークン数を見積もります。
+// 英数字は数文字で1トークン、日本語は1文字1トークン前後になるため、ASCII とそれ以外で分けて数える
+func estimateTokens(provider ChatProvider, text string) int {
+	ascii, other := 0, 0
+	for _, r := range text {
+		if r < utf8.RuneSelf {
+			ascii++
+		} else {
+			other++
+		}
+	}
+	asciiPerToken, otherPerRune := 4.0, 1.0 // OpenAI（o200k_base）
+	if _, ok := provider.(*AnthropicChatProvider); ok {
+		asciiPerToken, otherPerRune = 3.5, 1.3
+	}
+	return int(math.Ceil(float64(ascii)/asciiPerToken + float64(other)*otherPerRune))
+}
+
+func estimateMessagesTokens(provider ChatProvider, messages []OpenAIMessage) int {
+	total := 0
+	for _, m := range messages {
+		total += estimateTokens(provider, m.Content) + chatMessageTokenOverhead
+	}
+	return total
+}
+
+// PromptMessages は履歴を AI に渡すメッセージにします。
+// 各発言時点のコードは渡さない（今回の発言に最新のコードを付けるので、古いコードは不要）。
+// budget を超える場合は古いやり取りから落とす（要約がまだ済んでいない場合の保険）
+func (h ChatHistory) PromptMessages(provider ChatProvider, budget int) []OpenAIMessage {
+	messages := make([]OpenAIMessage, 0, len(h.Messages))
+	for _, m := range h.Messages {
+		messages = append(messages, OpenAIMessage{Role: m.Role, Content: m.Content})
+	}
+	start := 0
+	for tokens := estimateMessagesTokens(provider, messages); start < len(messages) && tokens > budget; start++ {
+		tokens -= estimateTokens(provider, messages[start].Content) + chatMessageTokenOverhead
+	}
+	// user の発言から始まるようにする（Anthropic は assistant から始まる履歴を受け付けない）
+	for start < len(messages) && messages[start].Role != "user" {
+		start++
+	}
+	if start > 0 {
+		log.Printf("INFO: chat history exceeds the token budget, dropped %d old messages", start)
+	}
+	return messages[start:]
+}
+
+// compactChatHistory は要約していない履歴が CHAT_HISTORY_TOKENS を超えたら、直近のやり取りを残して
+// 古いやり取りを要約にまとめます。まとめたら true
+func compactChatHistory(provider ChatProvider, h *ChatHistory) bool {
+	budget := chatHistoryTokens()
+	tokens := make([]int, len(h.Messages))
+	total := 0
+	for i, m := range h.Messages {
+		tokens[i] = estimateTokens(provider, m.Content) + chatMessageTokenOverhead
+		total += tokens[i]
+	}
+	if total <= budget {
+		return false
+	}
+
+	// 直近 CHAT_RECENT_MESSAGES 件と、予算の半分に収まる分はそのまま残す
+	kept, keptTokens := 0, 0
+	for i := len(h.Messages) - 1; i >= 0; i-- {
+		if kept >= chatRecentMessages() && keptTokens+tokens[i] > budget/2 {
+			break
+		}
+		kept++
+		keptTokens += tokens[i]
+	}
+	split := len(h.Messages) - kept
+	// 残す側が user の発言から始まるようにする
+	for split > 0 && split < len(h.Messages) && h.Messages[split].Role != "user" {
+		split++
+	}
+	if split <= 0 || split >= len(h.Messages) {
+		return false
+	}
+
+	summary, err := summarizeChatMessages(h.Summary, h.Messages[:split])
+	if err != nil {
+		log.Printf("ERROR: chat history summarize failed: %v", err)
+		return false
+	}
+	h.Summary = summary
+	if id := h.Messages[split-1].ID; id > 0 {
+		h.SummarizedUntil = id
+	}
+	h.Messages = append([]ChatSessionMessage(nil), h.Messages[split:]...)
+	return true
+}
+
+// 同じセッションの要約を1つずつ行うためのロック（セッション ID のハッシュで振り分ける）
+var chatCompactLocks [64]sync.Mutex
+
+// compactChatSession は会話が長ければ古いやり取りを要約にまとめ、セッションに保存します。
+// 同じセッションへの発言が続いても同じやり取りを二重に要約しないよう、セッションごとに1つずつ、
+// DB から最新の要約と要約していない会話を読み直してから要約する。h も最新の状態に置き換える
+func compactChatSession(provider ChatProvider, session *ChatSession, h *ChatHistory) {
+	if session == nil {
+		compactChatHistory(provider, h)
+		return
+	}
+	hash := fnv.New32a()
+	hash.Write([]byte(session.ID))
+	lock := &chatCompactLocks[hash.Sum32()%uint32(len(chatCompactLocks))]
+	lock.Lock()
+	defer lock.Unlock()
+
+	if latest, err := fetchChatSession(session.ID, session.UserID); err != nil {
+		log.Printf("ERROR: chat session reload failed: session_id=%s err=%v", session.ID, err)
+	} else if latest != nil {
+		messages, err := fetchUnsummarizedMessages(latest)
+		if err != nil {
+			log.Printf("ERROR: chat messages reload failed: session_id=%s err=%v", session.ID, err)
+		} else {
+			*h = ChatHistory{Summary: latest.Summary, SummarizedUntil: latest.SummarizedUntil, Messages: messages}
+		}
+	}
+	prevUntil := h.SummarizedUntil
+	if compactChatHistory(provider, h) {
+		saveChatSummary(session, *h, prevUntil)
+	}
+}
+
+// summarizeChatMessages はこれまでの要約に messages の内容を加えた新しい要約を AI に作らせます
+func summarizeChatMessages(summary string, messages []ChatSessionMessage) (string, error) {
+	var transcript strings.Builder
+	for _, m := range messages {
+		fmt.Fprintf(&transcript, "%s: %s\n", m.Role, m.Content)
+	}
+	if strings.TrimSpace(summary) == "" {
+		summary = "（まだありません）"
+	}
+	userPrompt := fmt.Sprintf("[Current Summary]\n%s\n\n[Older Conversation]\n%s", summary, transcript.String())
+
+	var res struct {
+		Summary string `json:"summary"`
+	}
+	if err := generateJSON(AIPurposeSummary, chatHistorySummarySystemPrompt, userPrompt, chatHistorySummarySchema, &res); err != nil {
+		return "", err
+	}
+	res.Summary = strings.TrimSpace(res.Summary)
+	if res.Summary == "" {
+		return "", fmt.Errorf("empty summary")
+	}
+	if runes := []rune(res.Summary); len(runes) > maxChatSummaryRunes {
+		res.Summary = string(runes[:maxChatSummaryRunes])
+	}
+	return res.Summary, nil
+}
+
+// saveChatSummary はセッションの要約と、要約に含めた最後のメッセージの ID を保存します。
+// 別のサーバーが先に要約を更新していた（summarized_until が prevUntil でない）場合は上書きしない
+func saveChatSummary(session *ChatSession, h ChatHistory, prevUntil int64) {
+	if session == nil {
+		return
+	}
+	session.Summary, session.SummarizedUntil = h.Summary, h.SummarizedUntil
+	updateData := map[string]interface{}{
+		"summary":          h.Summary,
+		"summarized_until": h.SummarizedUntil,
+	}
+	var updated interface{}
+	err := supabaseClient.DB.From("chat_sessions").Update(updateData).
+		Eq("id", session.ID).Eq("summarized_until", strconv.FormatInt(prevUntil, 10)).Execute(&updated)
+	if err != nil {
+		log.Printf("ERROR: chat summary update failed: session_id=%s err=%v", session.ID, err)
+	}
+}
+
+// fetchUnsummarizedMessages はセッションのうち、まだ要約に含めていない直近のメッセージを古い順に返します
+func fetchUnsummarizedMessages(session *ChatSession) ([]ChatSessionMessage, error) {
+	var messages []ChatSessionMessage
+	err := supabaseClient.DB.From("chat_messages").Select("*").OrderBy("id", "desc").Limit(maxLoadedChatMessages).
+		Eq("session_id", session.ID).Gt("id", strconv.FormatInt(session.SummarizedUntil, 10)).Execute(&messages)
+	if err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages)
+	return messages, nil
+}
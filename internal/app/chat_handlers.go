@@ -43,8 +43,10 @@ func chatWSHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var chatHistory []OpenAIMessage
-	const maxHistoryLen = 20
+	// 会話はセッションに保存し、再接続時は session_id から続きを読み込む。
+	// 保存できない場合（DB なし・ユーザー不明）はこの接続の間だけ履歴を保持する
+	var session *ChatSession
+	var chatHistory ChatHistory
 
 	for {
 		_, msgBytes, err := conn.ReadMessage()
@@ -64,6 +66,17 @@ func chatWSHandler(w http.ResponseWriter, r *http.Request) {
 			continue
 		}
 
+		// session_id を省略したメッセージは、この接続のセッションの続きとして扱う
+		if session == nil || (payload.SessionID != "" && payload.SessionID != session.ID) {
+			resolved, history, err := resolveChatSession(payload)
+			if err != nil {
+				log.Printf("ERROR(WS): chat session load failed: session_id=%s err=%v", payload.SessionID, err)
+			} else if resolved != nil {
+				session, chatHistory = resolved, history
+			}
+		}
+		applyChatSession(&payload, session)
+
 		chatRes, err := buildChatResponseStream(payload, chatProvider, chatHistory, conn)
 		if err != nil {
 			log.Printf("ERROR(WS): AI response generation failed: %v", err)
@@ -73,11 +86,9 @@ func chatWSHandler(w http.ResponseWriter, r *http.Request) {
 
 		//log.Printf("[Stream] params=%+v emo=%s", chatRes.Parameters, chatRes.Emotion)
 
-		chatHistory = append(chatHistory, OpenAIMessage{Role: "user", Content: payload.Message})
-		chatHistory = append(chatHistory, OpenAIMessage{Role: "assistant", Content: chatRes.Text})
-		if len(chatHistory) > maxHistoryLen {
-			chatHistory = chatHistory[len(chatHistory)-maxHistoryLen:]
-		}
+		// 返答を送り終えてから、履歴が長ければ古いやり取りを要約にまとめる
+		chatHistory.Messages = append(chatHistory.Messages, appendChatTurn(session, payload, chatRes)...)
+		compactChatSession(chatProvider, session, &chatHistory)
 	}
 }
 
@@ -101,13 +112,28 @@ func chatHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	chatRes, err := buildChatResponse(payload, chatProvider, nil)
+	session, history, err := resolveChatSession(payload)
+	if err != nil {
+		log.Printf("ERROR(/api/chat): chat session load failed: session_id=%s err=%v", payload.SessionID, err)
+	}
+	applyChatSession(&payload, session)
+
+	chatRes, err := buildChatResponse(payload, chatProvider, history)
 	if err != nil {
 		log.Printf("ERROR(/api/chat): %v", err)
 		http.Error(w, "Failed to communicate with AI", http.StatusBadGateway)
 		return
 	}
+	history.Messages = append(history.Messages, appendChatTurn(session, payload, chatRes)...)
+	// 要約は返答を待たせないよう裏で行う（次の発言はセッションから要約を読み込む）。
+	// 同じセッションの要約は compactChatSession が1つずつ行う
+	if session != nil {
+		go compactChatSession(chatProvider, session, &history)
+	}
 
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(chatRes)
+	json.NewEncoder(w).Encode(struct {
+		ChatResponse
+		SessionID string `json:"session_id,omitempty"`
+	}{chatRes, payload.SessionID})
 }
@@ -47,7 +47,9 @@ func profilePromptValues(userMem UserProfile) (string, string, string) {
 	return userName, memoryText, weaknessText
 }
 
-func buildChatPrompt(payload ChatPayload, history []OpenAIMessage, mode string) (string, []OpenAIMessage) {
+// buildChatPrompt はシステムプロンプトと AI に渡すメッセージを組み立てます。
+// 履歴は CHAT_CONTEXT_TOKENS からシステムプロンプトと今回の発言の分を引いた残り（最大 CHAT_HISTORY_TOKENS）に収める
+func buildChatPrompt(payload ChatPayload, provider ChatProvider, history ChatHistory, mode string) (string, []OpenAIMessage) {
 	userMem := fetchUserProfile(payload.UserID)
 	userName, memoryText, weaknessText := profilePromptValues(userMem)
 
@@ -61,30 +63,52 @@ func buildChatPrompt(payload ChatPayload, history []OpenAIMessage, mode string)
 	systemPrompt = strings.ReplaceAll(systemPrompt, "{{prev_output}}", payload.PrevOutput)
 	validateTemplateVars(systemPrompt)
 
+	taskText := payload.Task
+	var staticChecks []StaticCheck
+	if task := getTaskRegistry().Get(payload.TaskID); task != nil {
+		taskText = task.Description
+		staticChecks = task.StaticChecks
+	}
 	userContent := fmt.Sprintf(
 		"[Current Task]\n%s\n\n[User Code]\n%s\n\n[User Message]\n%s",
-		payload.Task,
+		taskText,
 		payload.Code,
 		payload.Message,
 	)
+	if history.Summary != "" {
+		userContent = "[Earlier Conversation Summary]\n" + history.Summary + "\n\n" + userContent
+	}
+	if len(payload.Diagnostics) > 0 {
+		userContent += "\n\n[Compiler Diagnostics]\n" + formatDiagnostics(payload.Diagnostics)
+	}
+	// 課題で指定された構文を使っているかは、いま編集中のコードで判定する
+	if findings := runStaticChecks(payload.Code, staticChecks); len(findings) > 0 {
+		userContent += "\n\n[Static Checks (required / forbidden constructs)]\n" + formatStaticFindings(findings)
+	}
+	if grading := gradingContextForChat(payload.UserID, payload.TaskID); grading != "" {
+		userContent += "\n\n[Latest Grading]\n" + grading
+	}
 
-	messages := make([]OpenAIMessage, 0, len(history)+1)
-	messages = append(messages, history...)
+	budget := chatContextTokens() - estimateTokens(provider, systemPrompt) - estimateTokens(provider, userContent)
+	budget = min(budget, chatHistoryTokens())
+	messages := history.PromptMessages(provider, max(budget, 0))
 	messages = append(messages, OpenAIMessage{Role: "user", Content: userContent})
 	return systemPrompt, messages
 }
 
-func parseChatAIContent(aiRawContent string, streaming bool) ChatResponse {
-	aiCleanContent := cleanJSONString(aiRawContent)
-	var chatRes ChatResponse
-	if err := json.Unmarshal([]byte(aiCleanContent), &chatRes); err != nil {
+// finalizeChatResponse は感情パラメータなどを範囲内に丸めます。
+// スキーマ検証と修正依頼の両方に失敗した場合は、生の出力をそのままテキストとして扱う
+func finalizeChatResponse(chatRes ChatResponse, aiRawContent string, parseErr error, streaming bool) ChatResponse {
+	if parseErr != nil {
+		aiCleanContent := cleanJSONString(aiRawContent)
 		if streaming {
-			log.Printf("WARNING: streaming AI response could not be parsed as JSON. raw: %s", aiCleanContent)
+			log.Printf("WARNING: streaming AI response could not be parsed as JSON: %v raw: %s", parseErr, aiCleanContent)
 		} else {
-			log.Printf("WARNING: AI response could not be parsed as JSON. raw: %s", aiCleanContent)
+			log.Printf("WARNING: AI response could not be parsed as JSON: %v raw: %s", parseErr, aiCleanContent)
 		}
 		chatRes = ChatResponse{Text: aiCleanContent, Emotion: "normal", LoveUp: 0}
 	}
+	normalizeChatResponse(&chatRes)
 
 	if os.Getenv("AI_DEBUG_MODE") == "true" && chatRes.Thought != "" {
 		log.Printf("Thought: %s", chatRes.Thought)
@@ -94,27 +118,28 @@ func parseChatAIContent(aiRawContent string, streaming bool) ChatResponse {
 	return chatRes
 }
 
-func buildChatResponse(payload ChatPayload, provider ChatProvider, history []OpenAIMessage) (ChatResponse, error) {
-	systemPrompt, messages := buildChatPrompt(payload, history, "thought")
+func buildChatResponse(payload ChatPayload, provider ChatProvider, history ChatHistory) (ChatResponse, error) {
+	systemPrompt, messages := buildChatPrompt(payload, provider, history, "thought")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
-	aiRawContent, err := provider.GenerateChat(ctx, systemPrompt, messages)
-	if err != nil {
+	var chatRes ChatResponse
+	aiRawContent, err := generateStructured(ctx, provider, systemPrompt, messages, chatThoughtSchema, &chatRes)
+	if err != nil && aiRawContent == "" {
 		return ChatResponse{}, err
 	}
-	return parseChatAIContent(aiRawContent, false), nil
+	return finalizeChatResponse(chatRes, aiRawContent, err, false), nil
 }
 
-func buildChatResponseStream(payload ChatPayload, provider ChatProvider, history []OpenAIMessage, conn *websocket.Conn) (ChatResponse, error) {
-	systemPrompt, messages := buildChatPrompt(payload, history, "stream")
+func buildChatResponseStream(payload ChatPayload, provider ChatProvider, history ChatHistory, conn *websocket.Conn) (ChatResponse, error) {
+	systemPrompt, messages := buildChatPrompt(payload, provider, history, "stream")
 
 	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
 	defer cancel()
 
 	var lastSentTextLen int
-	aiRawContent, err := provider.StreamChat(ctx, systemPrompt, messages, func(accumulated string) error {
+	aiRawContent, err := provider.StreamChat(ctx, systemPrompt, messages, chatStreamSchema, func(accumulated string) error {
 		currentText := extractPartialTextField(accumulated)
 		if len(currentText) <= lastSentTextLen {
 			return nil
@@ -132,7 +157,12 @@ func buildChatResponseStream(payload ChatPayload, provider ChatProvider, history
 		return ChatResponse{}, err
 	}
 
-	chatRes := parseChatAIContent(aiRawContent, true)
+	var chatRes ChatResponse
+	parseErr := parseStructuredOutput(aiRawContent, chatStreamSchema, &chatRes)
+	if parseErr != nil {
+		parseErr = repairStructuredOutput(ctx, provider, systemPrompt, messages, chatStreamSchema, aiRawContent, parseErr, &chatRes)
+	}
+	chatRes = finalizeChatResponse(chatRes, aiRawContent, parseErr, true)
 	if strings.TrimSpace(chatRes.Text) == "" {
 		log.Printf("WARNING: AI response text field is empty. raw: %s", cleanJSONString(aiRawContent))
 		chatRes.Text = "ごめん、うまく言葉にできなかった... もう一度聞いてくれる？"
@@ -145,6 +175,7 @@ func buildChatResponseStream(payload ChatPayload, provider ChatProvider, history
 		LoveUp:     chatRes.LoveUp,
 		Thought:    chatRes.Thought,
 		Parameters: chatRes.Parameters,
+		SessionID:  payload.SessionID,
 	}
 	if err := conn.WriteJSON(doneMsg); err != nil {
 		log.Printf("ERROR(WS): done send failed: %v", err)
@@ -8,24 +8,51 @@ import (
 	"strings"
 )
 
+// ChatProvider は JSON で応答する AI プロバイダ。
+// schema を渡すと、対応するプロバイダ（OpenAI）では Structured Outputs で形式を強制し、
+// 対応しないプロバイダではプロンプトでスキーマを指示する
 type ChatProvider interface {
-	GenerateChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage) (string, error)
-	StreamChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage, onDelta func(string) error) (string, error)
+	GenerateJSON(ctx context.Context, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema) (string, error)
+	StreamChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema, onDelta func(string) error) (string, error)
 }
 
+// AI を使う用途。用途ごとにプロバイダとモデルを切り替えられる
+const (
+	AIPurposeChat    = "chat"
+	AIPurposeGrade   = "grade"
+	AIPurposeSummary = "summary"
+)
+
 func getChatProvider() (ChatProvider, error) {
-	provider := strings.ToLower(strings.TrimSpace(os.Getenv("CHAT_AI_PROVIDER")))
+	return getAIProvider(AIPurposeChat)
+}
+
+// getAIProvider は用途ごとのプロバイダを返します。
+// <PURPOSE>_AI_PROVIDER / <PURPOSE>_AI_MODEL（例: GRADE_AI_PROVIDER）が未設定なら
+// CHAT_AI_PROVIDER と各プロバイダのデフォルトモデル（OPENAI_MODEL / ANTHROPIC_MODEL）を使う
+func getAIProvider(purpose string) (ChatProvider, error) {
+	prefix := strings.ToUpper(purpose)
+	provider := strings.ToLower(strings.TrimSpace(os.Getenv(prefix + "_AI_PROVIDER")))
+	if provider == "" {
+		provider = strings.ToLower(strings.TrimSpace(os.Getenv("CHAT_AI_PROVIDER")))
+	}
 	if provider == "" {
 		provider = "openai"
 	}
+	return newAIProvider(purpose, provider, strings.TrimSpace(os.Getenv(prefix+"_AI_MODEL")))
+}
 
+// newAIProvider は名前とモデルを指定してプロバイダを作ります。model が空なら各プロバイダのデフォルトモデル
+func newAIProvider(purpose, provider, model string) (ChatProvider, error) {
 	switch provider {
 	case "openai":
 		apiKey := os.Getenv("OPENAI_API_KEY")
 		if apiKey == "" {
 			return nil, fmt.Errorf("OPENAI_API_KEY is not configured")
 		}
-		model := os.Getenv("OPENAI_MODEL")
+		if model == "" {
+			model = os.Getenv("OPENAI_MODEL")
+		}
 		if model == "" {
 			model = "gpt-4o-mini"
 		}
@@ -35,7 +62,9 @@ func getChatProvider() (ChatProvider, error) {
 		if apiKey == "" {
 			return nil, fmt.Errorf("ANTHROPIC_API_KEY is not configured")
 		}
-		model := os.Getenv("ANTHROPIC_MODEL")
+		if model == "" {
+			model = os.Getenv("ANTHROPIC_MODEL")
+		}
 		if model == "" {
 			model = "claude-sonnet-4-5"
 		}
@@ -53,6 +82,18 @@ func getChatProvider() (ChatProvider, error) {
 		}
 		return &AnthropicChatProvider{APIKey: apiKey, Model: model, Version: version, MaxTokens: maxTokens}, nil
 	default:
-		return nil, fmt.Errorf("unsupported CHAT_AI_PROVIDER: %s", provider)
+		return nil, fmt.Errorf("unsupported AI provider for %s: %s", purpose, provider)
+	}
+}
+
+// aiModelName は記録用に "<provider>/<model>" 形式のモデル名を返します
+func aiModelName(provider ChatProvider) string {
+	switch p := provider.(type) {
+	case *OpenAIChatProvider:
+		return "openai/" + p.Model
+	case *AnthropicChatProvider:
+		return "anthropic/" + p.Model
+	default:
+		return fmt.Sprintf("%T", provider)
 	}
 }
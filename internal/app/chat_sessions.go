@@ -0,0 +1,310 @@
+package app
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+)
+
+// セッションから読み込む、要約していないメッセージの最大件数（user と assistant を合わせた数）。
+// 通常は毎回の返答のあとに要約するので、ここまで溜まることはない
+const maxLoadedChatMessages = 100
+
+// セッション一覧に表示するタイトル（最初のメッセージの先頭）の長さ
+const chatSessionTitleRunes = 40
+
+var chatSessionIDRegex = regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+
+// ChatSession はキャラクターとの会話1つ分（chat_sessions テーブル）。
+// 再接続やページの再読み込みのあとも session_id を送れば続きから話せる
+type ChatSession struct {
+	ID              string          `json:"id,omitempty"`
+	CreatedAt       string          `json:"created_at,omitempty"`
+	UpdatedAt       string          `json:"updated_at,omitempty"`
+	UserID          string          `json:"user_id"`
+	CharacterID     string          `json:"character_id"`
+	TaskID          string          `json:"task_id"`
+	Title           string          `json:"title"`
+	Emotion         string          `json:"emotion"`              // 最後の返答の表情
+	Parameters      json.RawMessage `json:"parameters,omitempty"` // 最後の返答の感情パラメータ
+	LoveLevel       int             `json:"love_level"`
+	Summary         string          `json:"summary"`          // 古いやり取りの要約
+	SummarizedUntil int64           `json:"summarized_until"` // 要約に含めた最後のメッセージの ID
+}
+
+// ChatSessionMessage はセッション内のメッセージ1件（chat_messages テーブル）
+type ChatSessionMessage struct {
+	ID         int64           `json:"id,omitempty"`
+	CreatedAt  string          `json:"created_at,omitempty"`
+	SessionID  string          `json:"session_id"`
+	Role       string          `json:"role"` // "user" or "assistant"
+	Content    string          `json:"content"`
+	Code       string          `json:"code,omitempty"`    // user の発言時に編集していたコード
+	TaskID     string          `json:"task_id,omitempty"` // user の発言時に開いていた課題
+	Emotion    string          `json:"emotion,omitempty"`
+	Parameters json.RawMessage `json:"parameters,omitempty"`
+	LoveUp     int             `json:"love_up,omitempty"`
+}
+
+// 新しいセッションを作るときのリクエストボディ（/api/chat/sessions への POST）
+type ChatSessionCreateRequest struct {
+	UserID      string `json:"user_id"`
+	CharacterID string `json:"character_id"`
+	TaskID      string `json:"task_id"`
+}
+
+func createChatSession(userID, characterID, taskID, firstMessage string) (*ChatSession, error) {
+	title := []rune(strings.TrimSpace(firstMessage))
+	if len(title) > chatSessionTitleRunes {
+		title = append(title[:chatSessionTitleRunes], '…')
+	}
+	var rows []ChatSession
+	session := ChatSession{UserID: userID, CharacterID: characterID, TaskID: taskID, Title: string(title)}
+	if err := supabaseClient.DB.From("chat_sessions").Insert(session).Execute(&rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return &session, nil
+	}
+	return &rows[0], nil
+}
+
+// fetchChatSession は userID のセッションを返します。無いか他のユーザーのものなら nil
+func fetchChatSession(sessionID, userID string) (*ChatSession, error) {
+	if !chatSessionIDRegex.MatchString(sessionID) {
+		return nil, nil
+	}
+	var rows []ChatSession
+	if err := supabaseClient.DB.From("chat_sessions").Select("*").Eq("id", sessionID).Eq("user_id", userID).Execute(&rows); err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+	return &rows[0], nil
+}
+
+// fetchChatMessages はセッションの直近 limit 件のメッセージを古い順に返します
+func fetchChatMessages(sessionID string, limit int) ([]ChatSessionMessage, error) {
+	var messages []ChatSessionMessage
+	if err := supabaseClient.DB.From("chat_messages").Select("*").OrderBy("id", "desc").Limit(limit).Eq("session_id", sessionID).Execute(&messages); err != nil {
+		return nil, err
+	}
+	slices.Reverse(messages)
+	return messages, nil
+}
+
+// resolveChatSession は payload の session_id のセッションと、その要約・要約していない会話を読み込みます。
+// session_id が無いか見つからない場合は新しいセッションを作る。DB が無いかユーザーが不明なら nil（会話は保存しない）
+func resolveChatSession(payload ChatPayload) (*ChatSession, ChatHistory, error) {
+	if supabaseClient == nil || payload.UserID == "" {
+		return nil, ChatHistory{}, nil
+	}
+	if payload.SessionID != "" {
+		session, err := fetchChatSession(payload.SessionID, payload.UserID)
+		if err != nil {
+			return nil, ChatHistory{}, err
+		}
+		if session != nil {
+			messages, err := fetchUnsummarizedMessages(session)
+			if err != nil {
+				return nil, ChatHistory{}, err
+			}
+			return session, ChatHistory{Summary: session.Summary, SummarizedUntil: session.SummarizedUntil, Messages: messages}, nil
+		}
+		log.Printf("WARNING: chat session not found, starting a new one: session_id=%s user_id=%s", payload.SessionID, payload.UserID)
+	}
+	session, err := createChatSession(payload.UserID, payload.CharacterID, payload.TaskID, payload.Message)
+	return session, ChatHistory{}, err
+}
+
+// applyChatSession は payload にセッションの ID を入れ、クライアントが感情パラメータを送っていなければ
+// セッションに保存した最後の感情パラメータを使います（ページを再読み込みしても気分が続くようにする）
+func applyChatSession(payload *ChatPayload, session *ChatSession) {
+	if session == nil {
+		return
+	}
+	payload.SessionID = session.ID
+	var zero ChatPayload
+	if payload.PrevParams == zero.PrevParams && len(session.Parameters) > 0 {
+		if err := json.Unmarshal(session.Parameters, &payload.PrevParams); err != nil {
+			log.Printf("WARNING: chat session parameters are invalid: session_id=%s err=%v", session.ID, err)
+		}
+	}
+}
+
+// appendChatTurn は1往復分のメッセージを保存し、セッションの最後の表情と感情パラメータを更新します。
+// 履歴に加えるメッセージ（保存できた場合は ID 付き）を返す。保存に失敗しても返答は止めない
+func appendChatTurn(session *ChatSession, payload ChatPayload, chatRes ChatResponse) []ChatSessionMessage {
+	params, _ := json.Marshal(chatRes.Parameters)
+	messages := []ChatSessionMessage{
+		{Role: "user", Content: payload.Message, Code: payload.Code, TaskID: payload.TaskID},
+		{Role: "assistant", Content: chatRes.Text, Emotion: chatRes.Emotion, Parameters: params, LoveUp: chatRes.LoveUp},
+	}
+	if session == nil {
+		return messages
+	}
+	for i := range messages {
+		messages[i].SessionID = session.ID
+	}
+	var inserted []ChatSessionMessage
+	if err := supabaseClient.DB.From("chat_messages").Insert(messages).Execute(&inserted); err != nil {
+		log.Printf("ERROR: chat message insert failed: session_id=%s err=%v", session.ID, err)
+		return messages
+	}
+	if len(inserted) == len(messages) {
+		messages = inserted
+	}
+
+	session.Emotion, session.Parameters, session.LoveLevel = chatRes.Emotion, params, payload.LoveLevel+chatRes.LoveUp
+	updateData := map[string]interface{}{
+		"updated_at": time.Now().UTC().Format(time.RFC3339),
+		"emotion":    session.Emotion,
+		"parameters": session.Parameters,
+		"love_level": session.LoveLevel,
+	}
+	if payload.TaskID != "" {
+		updateData["task_id"] = payload.TaskID
+	}
+	var updated interface{}
+	if err := supabaseClient.DB.From("chat_sessions").Update(updateData).Eq("id", session.ID).Execute(&updated); err != nil {
+		log.Printf("ERROR: chat session update failed: session_id=%s err=%v", session.ID, err)
+	}
+	return messages
+}
+
+// chatSessionsHandler は /api/chat/sessions で、
+// GET ?user_id=&character_id=&task_id=&limit= ならセッション一覧（新しい順）を、POST なら新しいセッションを返します
+func chatSessionsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if r.Method == http.MethodPost {
+		createChatSessionHandler(w, r)
+		return
+	}
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	userID := strings.TrimSpace(q.Get("user_id"))
+	if userID == "" {
+		writeJSONError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if supabaseClient == nil {
+		writeJSON(w, map[string]interface{}{"sessions": []ChatSession{}})
+		return
+	}
+
+	builder := supabaseClient.DB.From("chat_sessions").Select("*").OrderBy("updated_at", "desc").Limit(gradeAttemptsLimit(q.Get("limit"), 20, 100))
+	builder.Eq("user_id", userID)
+	if characterID := strings.TrimSpace(q.Get("character_id")); characterID != "" {
+		builder.Eq("character_id", characterID)
+	}
+	if taskID := strings.TrimSpace(q.Get("task_id")); taskID != "" {
+		builder.Eq("task_id", taskID)
+	}
+	var sessions []ChatSession
+	if err := builder.Execute(&sessions); err != nil {
+		log.Printf("ERROR: chat sessions fetch failed: user_id=%s err=%v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch chat sessions")
+		return
+	}
+	if sessions == nil {
+		sessions = []ChatSession{}
+	}
+	writeJSON(w, map[string]interface{}{"sessions": sessions})
+}
+
+func createChatSessionHandler(w http.ResponseWriter, r *http.Request) {
+	var req ChatSessionCreateRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	req.UserID = strings.TrimSpace(req.UserID)
+	if req.UserID == "" {
+		writeJSONError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if supabaseClient == nil {
+		writeJSONError(w, http.StatusServiceUnavailable, "Chat sessions are not available")
+		return
+	}
+	session, err := createChatSession(req.UserID, req.CharacterID, req.TaskID, "")
+	if err != nil {
+		log.Printf("ERROR: chat session create failed: user_id=%s err=%v", req.UserID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to create chat session")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"session": session})
+}
+
+// chatSessionDetailHandler は /api/chat/sessions/{id}?user_id=&limit= で、セッションと直近のメッセージ（古い順）を返します
+func chatSessionDetailHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	session, ok := lookupChatSession(w, r)
+	if !ok {
+		return
+	}
+	messages, err := fetchChatMessages(session.ID, gradeAttemptsLimit(r.URL.Query().Get("limit"), 100, 500))
+	if err != nil {
+		log.Printf("ERROR: chat messages fetch failed: session_id=%s err=%v", session.ID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch chat messages")
+		return
+	}
+	if messages == nil {
+		messages = []ChatSessionMessage{}
+	}
+	writeJSON(w, map[string]interface{}{"session": session, "messages": messages})
+}
+
+// chatSessionDeleteHandler は /api/chat/sessions/{id}/delete?user_id= でセッションとメッセージを削除します
+func chatSessionDeleteHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodPost) {
+		return
+	}
+	session, ok := lookupChatSession(w, r)
+	if !ok {
+		return
+	}
+	// chat_messages は外部キーの on delete cascade で消える
+	if err := deleteByFilter("chat_sessions", "id", session.ID); err != nil {
+		log.Printf("ERROR: chat session delete failed: session_id=%s err=%v", session.ID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to delete chat session")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "success"})
+}
+
+// lookupChatSession はパスの {id} と user_id クエリからセッションを引きます。見つからなければエラーを書いて false
+func lookupChatSession(w http.ResponseWriter, r *http.Request) (*ChatSession, bool) {
+	sessionID := r.PathValue("id")
+	userID := strings.TrimSpace(r.URL.Query().Get("user_id"))
+	if userID == "" {
+		writeJSONError(w, http.StatusBadRequest, "user_id is required")
+		return nil, false
+	}
+	if supabaseClient == nil {
+		writeJSONError(w, http.StatusNotFound, "Chat session not found")
+		return nil, false
+	}
+	session, err := fetchChatSession(sessionID, userID)
+	if err != nil {
+		log.Printf("ERROR: chat session fetch failed: session_id=%s err=%v", sessionID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch chat session")
+		return nil, false
+	}
+	if session == nil {
+		writeJSONError(w, http.StatusNotFound, "Chat session not found")
+		return nil, false
+	}
+	return session, true
+}
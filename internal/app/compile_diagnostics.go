@@ -0,0 +1,77 @@
+package app
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// g++ / clang++ の "file:line:col: severity: message" 形式（列番号は省略されることがある）
+var compilerDiagnosticRegex = regexp.MustCompile(`^(.+?):(\d+):(?:(\d+):)? (fatal error|error|warning|note): (.*)$`)
+
+// リンカのエラー（"/usr/bin/ld: ..." や "main.cpp:(.text+0x1f): undefined reference to ..."）
+var linkerDiagnosticRegex = regexp.MustCompile(`^(?:\S*/)?ld: (.*)$|^(\S+?):\(\.\w+[^)]*\): (.*)$`)
+
+// parseCompilerDiagnostics はコンパイラの stderr を行ごとに解析して診断情報を返します
+func parseCompilerDiagnostics(stderr string) []Diagnostic {
+	var diags []Diagnostic
+	for _, line := range strings.Split(strings.ReplaceAll(stderr, "\r\n", "\n"), "\n") {
+		if m := compilerDiagnosticRegex.FindStringSubmatch(line); m != nil {
+			lineNum, _ := strconv.Atoi(m[2])
+			column, _ := strconv.Atoi(m[3])
+			severity := m[4]
+			if severity == "fatal error" {
+				severity = "error"
+			}
+			diags = append(diags, Diagnostic{
+				File:     relativeSourcePath(m[1]),
+				Line:     lineNum,
+				Column:   column,
+				Severity: severity,
+				Message:  m[5],
+			})
+			continue
+		}
+		if m := linkerDiagnosticRegex.FindStringSubmatch(line); m != nil {
+			// "in function `main':" は後続行の文脈なので単独の診断にはしない
+			if strings.Contains(m[1], "in function") && strings.HasSuffix(m[1], ":") {
+				continue
+			}
+			diag := Diagnostic{Severity: "error", Message: m[1]}
+			if m[1] == "" {
+				diag.File = relativeSourcePath(m[2])
+				diag.Message = m[3]
+			}
+			diags = append(diags, diag)
+		}
+	}
+	return diags
+}
+
+// relativeSourcePath はサンドボックス内の絶対パスを提出ファイルからの相対パスに揃えます
+func relativeSourcePath(path string) string {
+	path = strings.TrimPrefix(path, "/usr/src/app/")
+	if idx := strings.Index(path, "/cpp-execution-"); idx >= 0 {
+		if slash := strings.IndexByte(path[idx+1:], '/'); slash >= 0 {
+			path = path[idx+1+slash+1:]
+		}
+	}
+	return strings.TrimPrefix(path, "./")
+}
+
+// formatDiagnostics はチャットのプロンプトに埋め込むための1行1件の文字列を返します
+func formatDiagnostics(diags []Diagnostic) string {
+	var b strings.Builder
+	for _, d := range diags {
+		switch {
+		case d.File == "":
+			b.WriteString(d.Severity + ": " + d.Message)
+		case d.Column > 0:
+			b.WriteString(d.File + ":" + strconv.Itoa(d.Line) + ":" + strconv.Itoa(d.Column) + ": " + d.Severity + ": " + d.Message)
+		default:
+			b.WriteString(d.File + ":" + strconv.Itoa(d.Line) + ": " + d.Severity + ": " + d.Message)
+		}
+		b.WriteString("\n")
+	}
+	return b.String()
+}
@@ -0,0 +1,156 @@
+package app
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strings"
+)
+
+// CompileOptions はサーバー側で検証済みのコンパイル設定
+type CompileOptions struct {
+	Compiler string   // "g++" or "clang++"
+	Std      string   // "c++17" など。空ならコンパイラのデフォルト
+	Optimize string   // "-O2" など。空なら指定なし
+	Flags    []string // allowedCompileFlags に含まれる追加フラグ
+	Sanitize bool     // ASan / UBSan を有効にしてビルドする
+	Sources  []string // コンパイル・リンクするソースファイル（dir からの相対パス）。空なら main.cpp
+}
+
+var allowedStandards = map[string]string{
+	"11": "c++11",
+	"14": "c++14",
+	"17": "c++17",
+	"20": "c++20",
+}
+
+var allowedOptimizeLevels = map[string]string{
+	"0": "-O0",
+	"1": "-O1",
+	"2": "-O2",
+	"3": "-O3",
+	"s": "-Os",
+}
+
+var allowedCompileFlags = map[string]bool{
+	"-Wextra":           true,
+	"-Wpedantic":        true,
+	"-pedantic-errors":  true,
+	"-Werror":           true,
+	"-Wshadow":          true,
+	"-Wconversion":      true,
+	"-Wsign-conversion": true,
+	"-g":                true,
+}
+
+const maxCompileFlags = 8
+
+// allowedCompilers は EXEC_COMPILERS（カンマ区切り）で利用可能なコンパイラを返します。
+// clang++ を使う場合は実行イメージ / ホストに clang が入っている必要がある
+func allowedCompilers() map[string]bool {
+	raw := strings.TrimSpace(os.Getenv("EXEC_COMPILERS"))
+	if raw == "" {
+		raw = "g++,clang++"
+	}
+	compilers := map[string]bool{}
+	for _, c := range strings.Split(raw, ",") {
+		if c = strings.TrimSpace(c); c == "g++" || c == "clang++" {
+			compilers[c] = true
+		}
+	}
+	return compilers
+}
+
+// compileOptionsFromPayload はリクエストのコンパイル設定を検証して CompileOptions に変換します
+func compileOptionsFromPayload(p CodePayload) (CompileOptions, error) {
+	opts := CompileOptions{Compiler: "g++"}
+
+	if c := strings.TrimSpace(p.Compiler); c != "" {
+		if !allowedCompilers()[c] {
+			return opts, fmt.Errorf("unsupported compiler: %s", c)
+		}
+		opts.Compiler = c
+	}
+
+	if std := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(p.Std)), "c++"); std != "" {
+		value, ok := allowedStandards[std]
+		if !ok {
+			return opts, fmt.Errorf("unsupported C++ standard: %s (use 11, 14, 17 or 20)", p.Std)
+		}
+		opts.Std = value
+	}
+
+	if level := strings.TrimPrefix(strings.TrimPrefix(strings.TrimSpace(p.Optimize), "-"), "O"); level != "" {
+		value, ok := allowedOptimizeLevels[level]
+		if !ok {
+			return opts, fmt.Errorf("unsupported optimization level: %s (use O0, O1, O2, O3 or Os)", p.Optimize)
+		}
+		opts.Optimize = value
+	}
+
+	if len(p.Flags) > maxCompileFlags {
+		return opts, fmt.Errorf("too many compiler flags (max %d)", maxCompileFlags)
+	}
+	switch strings.TrimSpace(p.Mode) {
+	case "", "normal":
+	case ExecModeSanitize:
+		opts.Sanitize = true
+	default:
+		return opts, fmt.Errorf("unsupported mode: %s", p.Mode)
+	}
+
+	seen := map[string]bool{}
+	for _, flag := range p.Flags {
+		flag = strings.TrimSpace(flag)
+		if !allowedCompileFlags[flag] {
+			return opts, fmt.Errorf("compiler flag is not allowed: %s", flag)
+		}
+		if !seen[flag] {
+			seen[flag] = true
+			opts.Flags = append(opts.Flags, flag)
+		}
+	}
+
+	files, err := projectFilesFromPayload(p)
+	if err != nil {
+		return opts, err
+	}
+	if opts.Sources, err = projectSources(files, p.Entry); err != nil {
+		return opts, err
+	}
+	return opts, nil
+}
+
+// Args はソースファイルと出力先を除いたコンパイラ引数を返します
+func (o CompileOptions) Args() []string {
+	args := []string{"-Wall"}
+	if o.Std != "" {
+		args = append(args, "-std="+o.Std)
+	}
+	if o.Sanitize {
+		args = append(args, sanitizerCompileFlags...)
+	}
+	if o.Optimize != "" {
+		args = append(args, o.Optimize)
+	}
+	return append(args, o.Flags...)
+}
+
+// SourceArgs は root をインクルードパスに加え、全ソースを root/main.out にリンクする引数を返します
+func (o CompileOptions) SourceArgs(root string) []string {
+	sources := o.Sources
+	if len(sources) == 0 {
+		sources = []string{"main.cpp"}
+	}
+	args := []string{"-I" + root}
+	for _, s := range sources {
+		args = append(args, path.Join(root, s))
+	}
+	return append(args, "-o", path.Join(root, "main.out"))
+}
+
+// Command は sh -c で実行するコンパイルコマンドを返します。
+// 引数は許可リスト由来、ファイル名は projectPathRegex で検証済みなのでクォート不要
+func (o CompileOptions) Command(root string) string {
+	return o.Compiler + " " + strings.Join(append(o.Args(), o.SourceArgs(root)...), " ")
+}
@@ -0,0 +1,96 @@
+package app
+
+import "strings"
+
+// C++ のトークンの種類
+const (
+	cppTokenIdent  = "ident" // 識別子・キーワード
+	cppTokenNumber = "number"
+	cppTokenString = "string"
+	cppTokenChar   = "char"
+	cppTokenOp     = "op" // 演算子・区切り記号
+)
+
+type cppToken struct {
+	Kind string
+	Text string
+}
+
+// 2文字以上の演算子（長いものから順に照合する）
+var cppOperators = []string{
+	"<<=", ">>=", "...", "->*", "<=>",
+	"::", "->", "++", "--", "<<", ">>", "<=", ">=", "==", "!=", "&&", "||", "+=", "-=", "*=", "/=", "%=", "&=", "|=", "^=",
+}
+
+// lexCpp は C++ のコードを大まかにトークンに分けます（類似度判定・静的チェック用で、厳密な字句解析ではない）。
+// コメントとプリプロセッサ行は捨てる
+func lexCpp(code string) []cppToken {
+	src := []rune(code)
+	var tokens []cppToken
+	lineStart := true
+	for i := 0; i < len(src); i++ {
+		r := src[i]
+		switch {
+		case r == '\n':
+			lineStart = true
+		case r == ' ' || r == '\t' || r == '\r' || r == '\f' || r == '\v':
+		case r == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i+1 < len(src) && src[i+1] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(src) && src[i+1] == '*':
+			for i += 2; i+1 < len(src) && !(src[i] == '*' && src[i+1] == '/'); i++ {
+			}
+			i++
+		case r == '#' && lineStart:
+			// 行継続も含めて読み飛ばす
+			for i+1 < len(src) && (src[i+1] != '\n' || src[i] == '\\') {
+				i++
+			}
+		case r == '"' || r == '\'':
+			start := i
+			for i+1 < len(src) {
+				i++
+				if src[i] == '\\' {
+					i++
+					continue
+				}
+				if src[i] == r || src[i] == '\n' {
+					break
+				}
+			}
+			kind := cppTokenString
+			if r == '\'' {
+				kind = cppTokenChar
+			}
+			tokens = append(tokens, cppToken{Kind: kind, Text: string(src[start:min(i+1, len(src))])})
+			lineStart = false
+		case r >= '0' && r <= '9':
+			start := i
+			for i+1 < len(src) && (isWordRune(src[i+1]) || src[i+1] == '.' || src[i+1] == '\'') {
+				i++
+			}
+			tokens = append(tokens, cppToken{Kind: cppTokenNumber, Text: string(src[start : i+1])})
+			lineStart = false
+		case isWordRune(r):
+			start := i
+			for i+1 < len(src) && isWordRune(src[i+1]) {
+				i++
+			}
+			tokens = append(tokens, cppToken{Kind: cppTokenIdent, Text: string(src[start : i+1])})
+			lineStart = false
+		default:
+			op := string(r)
+			for _, candidate := range cppOperators {
+				if strings.HasPrefix(string(src[i:min(i+len(candidate), len(src))]), candidate) {
+					op = candidate
+					break
+				}
+			}
+			i += len(op) - 1
+			tokens = append(tokens, cppToken{Kind: cppTokenOp, Text: op})
+			lineStart = false
+		}
+	}
+	return tokens
+}
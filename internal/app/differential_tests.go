@@ -0,0 +1,310 @@
+package app
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math"
+	"math/rand/v2"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	defaultRandomTestCount = 20
+	maxRandomTestCount     = 30
+	maxRandomInputBytes    = 64 * 1024
+	randomCasePrefix       = "random-"
+)
+
+// RandomTestSpec は模範解答との比較に使うランダム入力の定義。
+// Input はテンプレートで、{...} の部分が乱数で置き換わる:
+//
+//	{int:MIN:MAX}             MIN 以上 MAX 以下の整数
+//	{n=int:MIN:MAX}           整数を生成して n という名前で覚える
+//	{ints:COUNT:MIN:MAX}      空白区切りの整数 COUNT 個（COUNT は数値か覚えた名前）
+//	{str:MINLEN:MAXLEN[:ABC]} 長さ MINLEN〜MAXLEN の文字列（文字の種類は ABC、省略時は英小文字）
+type RandomTestSpec struct {
+	Count int    `json:"count,omitempty"` // 生成する入力の数（デフォルト 20、最大 30）
+	Seed  uint64 `json:"seed,omitempty"`  // 同じ提出には毎回同じ入力を使うため固定のシードにする
+	Input string `json:"input"`
+}
+
+// Counterexample は提出と模範解答の出力が最初に食い違った入力
+type Counterexample struct {
+	Input          string `json:"input"`
+	ExpectedOutput string `json:"expected_output"`
+	ActualOutput   string `json:"actual_output"`
+	Verdict        string `json:"verdict"`
+	Diff           string `json:"diff,omitempty"`
+}
+
+var randomPlaceholderRegex = regexp.MustCompile(`\{([^{}]*)\}`)
+
+// generateRandomInputs は仕様に従って入力を生成します
+func generateRandomInputs(spec RandomTestSpec) ([]string, error) {
+	count := spec.Count
+	if count <= 0 {
+		count = defaultRandomTestCount
+	}
+	count = min(count, maxRandomTestCount)
+	inputs := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		input, err := generateRandomInput(spec.Input, rand.New(rand.NewPCG(spec.Seed, uint64(i))))
+		if err != nil {
+			return nil, err
+		}
+		inputs = append(inputs, input)
+	}
+	return inputs, nil
+}
+
+func generateRandomInput(template string, rng *rand.Rand) (string, error) {
+	vars := map[string]int64{}
+	var genErr error
+	out := randomPlaceholderRegex.ReplaceAllStringFunc(template, func(m string) string {
+		if genErr != nil {
+			return ""
+		}
+		value, err := expandRandomPlaceholder(m[1:len(m)-1], vars, rng)
+		if err != nil {
+			genErr = fmt.Errorf("placeholder %s: %w", m, err)
+		}
+		return value
+	})
+	if genErr != nil {
+		return "", genErr
+	}
+	if len(out) > maxRandomInputBytes {
+		return "", fmt.Errorf("generated input is too large (max %d bytes)", maxRandomInputBytes)
+	}
+	return out, nil
+}
+
+func expandRandomPlaceholder(expr string, vars map[string]int64, rng *rand.Rand) (string, error) {
+	name := ""
+	if before, after, ok := strings.Cut(expr, "="); ok && !strings.Contains(before, ":") {
+		name, expr = strings.TrimSpace(before), after
+	}
+	parts := strings.Split(expr, ":")
+	kind := strings.TrimSpace(parts[0])
+
+	switch {
+	case kind == "int" && len(parts) == 3:
+		lo, hi, err := randomRange(parts[1], parts[2], vars)
+		if err != nil {
+			return "", err
+		}
+		v := randomInt64(rng, lo, hi)
+		if name != "" {
+			vars[name] = v
+		}
+		return strconv.FormatInt(v, 10), nil
+	case kind == "ints" && len(parts) == 4 && name == "":
+		n, err := randomOperand(parts[1], vars)
+		if err != nil {
+			return "", err
+		}
+		if n < 0 || n > maxRandomInputBytes/2 {
+			return "", fmt.Errorf("count %d is out of range", n)
+		}
+		lo, hi, err := randomRange(parts[2], parts[3], vars)
+		if err != nil {
+			return "", err
+		}
+		values := make([]string, n)
+		for i := range values {
+			values[i] = strconv.FormatInt(randomInt64(rng, lo, hi), 10)
+		}
+		return strings.Join(values, " "), nil
+	case kind == "str" && (len(parts) == 3 || len(parts) == 4) && name == "":
+		lo, hi, err := randomRange(parts[1], parts[2], vars)
+		if err != nil {
+			return "", err
+		}
+		if lo < 0 || hi > maxRandomInputBytes {
+			return "", fmt.Errorf("length is out of range")
+		}
+		alphabet := []rune("abcdefghijklmnopqrstuvwxyz")
+		if len(parts) == 4 && parts[3] != "" {
+			alphabet = []rune(parts[3])
+		}
+		s := make([]rune, randomInt64(rng, lo, hi))
+		for i := range s {
+			s[i] = alphabet[rng.IntN(len(alphabet))]
+		}
+		return string(s), nil
+	default:
+		return "", fmt.Errorf("unsupported placeholder")
+	}
+}
+
+func randomRange(loRaw, hiRaw string, vars map[string]int64) (int64, int64, error) {
+	lo, err := randomOperand(loRaw, vars)
+	if err != nil {
+		return 0, 0, err
+	}
+	hi, err := randomOperand(hiRaw, vars)
+	if err != nil {
+		return 0, 0, err
+	}
+	if lo > hi {
+		return 0, 0, fmt.Errorf("min %d is greater than max %d", lo, hi)
+	}
+	return lo, hi, nil
+}
+
+// randomInt64 は lo 以上 hi 以下の乱数を返します。hi-lo+1 が int64 に収まらない範囲でも溢れないよう uint64 で計算する
+func randomInt64(rng *rand.Rand, lo, hi int64) int64 {
+	span := uint64(hi) - uint64(lo)
+	if span == math.MaxUint64 {
+		return int64(rng.Uint64())
+	}
+	return lo + int64(rng.Uint64N(span+1))
+}
+
+func randomOperand(raw string, vars map[string]int64) (int64, error) {
+	raw = strings.TrimSpace(raw)
+	if v, ok := vars[raw]; ok {
+		return v, nil
+	}
+	v, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("%q is neither a number nor a defined name", raw)
+	}
+	return v, nil
+}
+
+var (
+	referenceCasesMu     sync.Mutex
+	referenceCases       = map[string][]TestCase{}
+	referenceCasesFlight singleflight.Group // 同じ模範解答の実行を、同時に採点する提出の間で1回にまとめる
+)
+
+// differentialCases は模範解答をランダム入力で実行し、その出力を想定出力とするテストケースを返します。
+// 課題にランダム入力か模範解答が無ければ nil。結果は模範解答と入力の定義ごとに保持する
+func differentialCases(ctx context.Context, task *Task) ([]TestCase, error) {
+	if task.RandomTests == nil || strings.TrimSpace(task.ReferenceSolution) == "" {
+		return nil, nil
+	}
+	inputs, err := generateRandomInputs(*task.RandomTests)
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256([]byte(fmt.Sprintf("%s\x00%d\x00%s\x00%d", task.ReferenceSolution, task.RandomTests.Seed, task.RandomTests.Input, len(inputs))))
+	key := hex.EncodeToString(sum[:])
+	referenceCasesMu.Lock()
+	cached, ok := referenceCases[key]
+	referenceCasesMu.Unlock()
+	if ok {
+		return cached, nil
+	}
+
+	// 最初に来た提出の切断で他の提出の採点が失敗しないよう、キャンセルは引き継がない
+	result, err, _ := referenceCasesFlight.Do(key, func() (interface{}, error) {
+		cases, err := runReferenceSolution(context.WithoutCancel(ctx), task, inputs, "reference:"+key)
+		if err != nil {
+			return nil, err
+		}
+		referenceCasesMu.Lock()
+		referenceCases[key] = cases
+		referenceCasesMu.Unlock()
+		return cases, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.([]TestCase), nil
+}
+
+// runReferenceSolution は模範解答をビルドして各入力で実行します。模範解答が正常終了しなかった入力は使わない。
+// queueKey は実行キューでの利用者のキーで、模範解答と入力の定義ごとに1つずつしか実行しないため上限に掛からない
+func runReferenceSolution(ctx context.Context, task *Task, inputs []string, queueKey string) ([]TestCase, error) {
+	payload := CodePayload{Code: task.ReferenceSolution}
+	opts, err := compileOptionsFromPayload(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	queueCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	release, err := getExecQueue().Acquire(queueCtx, ExecClient{User: queueKey})
+	cancel()
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	dir, err := prepareSourceDir(payload)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(dir)
+
+	sandbox, err := getSandbox()
+	if err != nil {
+		return nil, err
+	}
+	compileCtx, cancelCompile := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancelCompile()
+	compiled, err := sandbox.Compile(compileCtx, dir, opts)
+	if err != nil {
+		return nil, err
+	}
+	if compiled.TimedOut || compiled.ExitCode != 0 {
+		return nil, fmt.Errorf("reference solution of task %s does not compile: %s", task.ID, compiled.Stderr)
+	}
+
+	limits := sandboxLimitsFor(opts, maxCaseTimeLimit)
+	cases := make([]TestCase, 0, len(inputs))
+	for i, input := range inputs {
+		run, err := runSandbox(context.Background(), sandbox, dir, input, limits)
+		if err != nil {
+			return nil, err
+		}
+		if run.TimedOut || run.ExitCode != 0 || run.Truncated {
+			log.Printf("WARNING: reference solution of task %s failed on random input %d, skipping it", task.ID, i+1)
+			continue
+		}
+		cases = append(cases, TestCase{Name: fmt.Sprintf("%s%d", randomCasePrefix, i+1), Input: input, ExpectedOutput: run.Stdout})
+	}
+	return cases, nil
+}
+
+// firstCounterexample は判定結果から、最初に不合格になったランダム入力のケースを返します
+func firstCounterexample(results []CaseResult, cases []TestCase) *Counterexample {
+	byName := map[string]TestCase{}
+	for _, tc := range cases {
+		byName[tc.Name] = tc
+	}
+	for _, res := range results {
+		tc, ok := byName[res.Name]
+		if !ok || res.Verdict == VerdictAccepted {
+			continue
+		}
+		return &Counterexample{
+			Input:          tc.Input,
+			ExpectedOutput: tc.ExpectedOutput,
+			ActualOutput:   res.Output,
+			Verdict:        res.Verdict,
+			Diff:           res.Diff,
+		}
+	}
+	return nil
+}
+
+// formatCounterexample は AI に渡すための反例の説明を返します
+func formatCounterexample(c *Counterexample) string {
+	if c == nil {
+		return "なし（ランダム入力ではすべて模範解答と一致）"
+	}
+	return fmt.Sprintf("判定: %s\n入力:\n%s\n模範解答の出力:\n%s\n提出コードの出力:\n%s", c.Verdict, c.Input, c.ExpectedOutput, c.ActualOutput)
+}
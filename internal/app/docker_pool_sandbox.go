@@ -0,0 +1,161 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const dockerPoolLabel = "goserver.exec-pool=1"
+
+var errSandboxBusy = errors.New("execution queue is full")
+
+var (
+	dockerPool     *DockerPoolSandbox
+	dockerPoolOnce sync.Once
+)
+
+// DockerPoolSandbox は起動済みのワーカーコンテナ（--net=none）を使い回すバックエンド。
+// ジョブごとにソースを docker cp で持ち込み、終わったコンテナは破棄して裏で補充する
+type DockerPoolSandbox struct {
+	Image  string
+	Size   int
+	Limits SandboxLimits // コンテナ単位の制限（メモリ / CPU / プロセス数 / 出力量）
+
+	slots chan struct{} // 実行中 + 待機中のジョブ数の上限
+	idle  chan string   // 空いているワーカーのコンテナ名
+	seq   atomic.Int64
+}
+
+func getDockerPool(image string, size int, queue int, limits SandboxLimits) *DockerPoolSandbox {
+	dockerPoolOnce.Do(func() {
+		dockerPool = &DockerPoolSandbox{
+			Image:  image,
+			Size:   size,
+			Limits: limits,
+			slots:  make(chan struct{}, size+queue),
+			idle:   make(chan string, size),
+		}
+		dockerPool.start()
+	})
+	return dockerPool
+}
+
+func (p *DockerPoolSandbox) start() {
+	// 前回のプロセスが残したワーカーを掃除してから起動する
+	out, err := exec.Command("docker", "ps", "-aq", "--filter", "label="+dockerPoolLabel).Output()
+	if err == nil {
+		if ids := strings.Fields(string(out)); len(ids) > 0 {
+			exec.Command("docker", append([]string{"rm", "-f"}, ids...)...).Run()
+		}
+	}
+	for i := 0; i < p.Size; i++ {
+		go p.spawn()
+	}
+	log.Printf("INFO: docker exec pool starting: size=%d queue=%d image=%s", p.Size, cap(p.slots)-p.Size, p.Image)
+}
+
+func (p *DockerPoolSandbox) spawn() {
+	backoff := time.Second
+	for {
+		name := fmt.Sprintf("cpp-worker-%d", p.seq.Add(1))
+		args := []string{"run", "-d", "--rm", "--net=none", "--label", dockerPoolLabel, "--name", name, "-w", "/usr/src/app"}
+		args = append(args, dockerLimitArgs(p.Limits)...)
+		args = append(args, p.Image, "sleep", "infinity")
+
+		var stderr bytes.Buffer
+		cmd := exec.Command("docker", args...)
+		cmd.Stderr = &stderr
+		if err := cmd.Run(); err != nil {
+			log.Printf("ERROR: docker exec pool worker start failed: %v %s", err, strings.TrimSpace(stderr.String()))
+			time.Sleep(backoff)
+			if backoff < time.Minute {
+				backoff *= 2
+			}
+			continue
+		}
+		p.idle <- name
+		return
+	}
+}
+
+func (p *DockerPoolSandbox) acquire(ctx context.Context) (string, error) {
+	select {
+	case p.slots <- struct{}{}:
+	default:
+		return "", errSandboxBusy
+	}
+	select {
+	case name := <-p.idle:
+		return name, nil
+	case <-ctx.Done():
+		<-p.slots
+		return "", fmt.Errorf("waiting for docker exec pool worker: %w", ctx.Err())
+	}
+}
+
+// recycle は使用済みワーカーを破棄し、新しいワーカーを補充します
+func (p *DockerPoolSandbox) recycle(name string) {
+	<-p.slots
+	go func() {
+		exec.Command("docker", "rm", "-f", name).Run()
+		p.spawn()
+	}()
+}
+
+func (p *DockerPoolSandbox) Compile(ctx context.Context, dir string, opts CompileOptions) (SandboxResult, error) {
+	stdio, out, stderr := bufferedIO()
+	res, _, err := p.exec(ctx, dir, opts.Command("."), stdio, "main.out")
+	res.Stdout = out.String()
+	res.Stderr = stderr.String()
+	return res, err
+}
+
+func (p *DockerPoolSandbox) Run(ctx context.Context, dir string, stdio SandboxIO, limits SandboxLimits) (SandboxResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, limits.TimeLimit+10*time.Second)
+	defer cancel()
+
+	res, status, err := p.exec(ctx, dir, dockerRunScript("./main.out", limits, stdio.LineBuffered), stdio, "")
+	applyDockerRunResult(&res, status)
+	return res, err
+}
+
+// exec はワーカーに dir の中身をコピーしてスクリプトを実行します。
+// 正常終了時は copyBack をホストに戻し、ワーカーに残った runStatusFile の内容も返す
+func (p *DockerPoolSandbox) exec(ctx context.Context, dir string, script string, stdio SandboxIO, copyBack string) (SandboxResult, string, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	name, err := p.acquire(ctx)
+	if err != nil {
+		return SandboxResult{}, "", err
+	}
+	defer p.recycle(name)
+
+	if out, err := exec.CommandContext(ctx, "docker", "cp", dir+"/.", name+":/usr/src/app").CombinedOutput(); err != nil {
+		return SandboxResult{}, "", fmt.Errorf("docker cp to worker failed: %w %s", err, strings.TrimSpace(string(out)))
+	}
+
+	cmd := exec.CommandContext(ctx, "docker", "exec", "-i", name, "sh", "-c", script)
+	res, err := runSandboxCmd(ctx, cancel, cmd, stdio, p.Limits.OutputBytes)
+	if err != nil || res.TimedOut {
+		return res, "", err
+	}
+
+	status, _ := exec.Command("docker", "exec", name, "cat", "/usr/src/app/"+runStatusFile).Output()
+	if copyBack != "" && res.ExitCode == 0 {
+		src := name + ":/usr/src/app/" + copyBack
+		if out, err := exec.CommandContext(ctx, "docker", "cp", src, filepath.Join(dir, copyBack)).CombinedOutput(); err != nil {
+			return res, "", fmt.Errorf("docker cp from worker failed: %w %s", err, strings.TrimSpace(string(out)))
+		}
+	}
+	return res, string(status), nil
+}
@@ -0,0 +1,114 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// 実行スクリプトがコンテナの cgroup のピークメモリ（バイト）と、制限時間で打ち切った場合は "timeout" を書き出すファイル
+const runStatusFile = ".run_status"
+
+// 提出プログラムが自分で終了した場合に作られるファイル。終了コード 124 が timeout によるものかを区別する
+const exitedMarkerFile = ".exited"
+
+var dockerRunSeq atomic.Int64
+
+type DockerSandbox struct {
+	Image string
+}
+
+func (s *DockerSandbox) Compile(ctx context.Context, dir string, opts CompileOptions) (SandboxResult, error) {
+	stdio, out, stderr := bufferedIO()
+	res, err := s.run(ctx, dir, opts.Command("/usr/src/app"), stdio, compileSandboxLimits())
+	res.Stdout = out.String()
+	res.Stderr = stderr.String()
+	return res, err
+}
+
+func (s *DockerSandbox) Run(ctx context.Context, dir string, stdio SandboxIO, limits SandboxLimits) (SandboxResult, error) {
+	ctx, cancel := context.WithTimeout(ctx, limits.TimeLimit+10*time.Second)
+	defer cancel()
+
+	res, err := s.run(ctx, dir, dockerRunScript("/usr/src/app/main.out", limits, stdio.LineBuffered), stdio, limits)
+	status, _ := os.ReadFile(filepath.Join(dir, runStatusFile))
+	applyDockerRunResult(&res, string(status))
+	return res, err
+}
+
+// run は dir を /usr/src/app にマウントしたコンテナでスクリプトを実行します
+func (s *DockerSandbox) run(ctx context.Context, dir string, script string, stdio SandboxIO, limits SandboxLimits) (SandboxResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	name := fmt.Sprintf("cpp-run-%d-%d", time.Now().UnixNano(), dockerRunSeq.Add(1))
+	args := []string{"run", "--rm", "-i", "--net=none", "--name", name}
+	args = append(args, dockerLimitArgs(limits)...)
+	args = append(args,
+		"-v", fmt.Sprintf("%s:/usr/src/app", dir),
+		s.Image,
+		"sh", "-c", script,
+	)
+	cmd := exec.CommandContext(ctx, "docker", args...)
+	// docker クライアントを kill してもコンテナは止まらないため、コンテナごと削除する
+	cmd.Cancel = func() error {
+		exec.Command("docker", "rm", "-f", name).Run()
+		return cmd.Process.Kill()
+	}
+	return runSandboxCmd(ctx, cancel, cmd, stdio, limits.OutputBytes)
+}
+
+func dockerLimitArgs(limits SandboxLimits) []string {
+	var args []string
+	if limits.MemoryMB > 0 {
+		args = append(args, fmt.Sprintf("--memory=%dm", limits.MemoryMB), fmt.Sprintf("--memory-swap=%dm", limits.MemoryMB))
+	}
+	if limits.CPUs > 0 {
+		args = append(args, fmt.Sprintf("--cpus=%g", limits.CPUs))
+	}
+	if limits.Pids > 0 {
+		args = append(args, fmt.Sprintf("--pids-limit=%d", limits.Pids))
+	}
+	return args
+}
+
+// dockerRunScript は制限時間付きで binary を実行し、最後にコンテナの cgroup のピークメモリと打ち切りの有無を runStatusFile に書き出します。
+// binary は sh 経由で起動し、自分で終了した場合だけ exitedMarkerFile を作るので、exit(124) と timeout を取り違えない
+func dockerRunScript(binary string, limits SandboxLimits, lineBuffered bool) string {
+	if lineBuffered {
+		binary = "stdbuf -oL " + binary
+	}
+	if limits.Sanitized {
+		binary = "env " + strings.Join(sanitizerEnv, " ") + " " + binary
+	}
+	const app = "/usr/src/app/"
+	return fmt.Sprintf(
+		"rm -f %[3]s%[4]s; "+
+			"timeout %.3[1]f sh -c '\"$@\"; c=$?; : > %[3]s%[4]s; exit $c' sh %[2]s; code=$?; "+
+			"{ (cat /sys/fs/cgroup/memory.peak || cat /sys/fs/cgroup/memory/memory.max_usage_in_bytes) 2>/dev/null; "+
+			"if [ $code -eq 124 ] && [ ! -e %[3]s%[4]s ]; then echo timeout; fi; } > %[3]s%[5]s; exit $code",
+		limits.TimeLimit.Seconds(), binary, app, exitedMarkerFile, runStatusFile,
+	)
+}
+
+// applyDockerRunResult は runStatusFile の内容からピークメモリと TLE を反映し、終了コードからシグナルを判定します
+func applyDockerRunResult(res *SandboxResult, status string) {
+	for _, line := range strings.Split(status, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "timeout" {
+			res.TimedOut = true
+		} else if peakBytes, err := strconv.ParseInt(line, 10, 64); err == nil {
+			res.PeakMemoryKB = peakBytes / 1024
+		}
+	}
+	if res.TimedOut {
+		return
+	}
+	res.Signal = shellExitSignal(res.ExitCode)
+}
@@ -0,0 +1,322 @@
+package app
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	errExecQueueFull = errors.New("execution queue is full")
+	errExecUserBusy  = errors.New("too many executions in progress for this user")
+	errExecIPBusy    = errors.New("too many executions in progress from this address")
+)
+
+const (
+	ExecJobQueued  = "queued"
+	ExecJobRunning = "running"
+	ExecJobDone    = "done"
+)
+
+var (
+	execQueueInstance *ExecQueue
+	execQueueOnce     sync.Once
+)
+
+// ExecQueue はコンパイル・実行の同時実行数を制限するワーカープール。
+// 空きが無いときは待機列に入り、ユーザーごとのラウンドロビンで順番に実行枠を割り当てる
+type ExecQueue struct {
+	Workers   int // 同時に実行できるジョブ数
+	MaxQueued int // 全体の待機列の上限
+	PerUser   int // 1ユーザーあたりの実行中 + 待機中ジョブ数の上限
+	PerIP     int // 1接続元 IP あたりの上限。user_id を変えて PerUser を回避されないよう、PerUser より十分大きくする
+	JobTTL    time.Duration
+
+	mu      sync.Mutex
+	running int
+	queued  int
+	active  map[string]int           // ユーザーごとの実行中 + 待機中の数
+	ipCount map[string]int           // 接続元 IP ごとの実行中 + 待機中の数
+	waiting map[string][]*execTicket // ユーザーごとの待機列
+	users   []string                 // 待機中のジョブを持つユーザー（次に割り当てる順）
+	jobs    map[string]*ExecJob
+}
+
+// ExecClient は実行を要求した利用者
+type ExecClient struct {
+	User string // 公平性の単位（ユーザーごとの上限とラウンドロビン）
+	IP   string // 接続元 IP。空でなければ IP ごとの上限も課す
+}
+
+type execTicket struct {
+	user    string
+	ip      string
+	ready   chan struct{}
+	granted bool
+}
+
+// ExecJob は /api/execute で受け付けた1件の実行。状態は /api/execute/status/{id} で参照できる
+type ExecJob struct {
+	ID string
+
+	queue      *ExecQueue
+	ticket     *execTicket
+	started    chan struct{}
+	done       chan struct{}
+	mu         sync.Mutex
+	status     string
+	result     *ResultPayload
+	finishedAt time.Time
+}
+
+func getExecQueue() *ExecQueue {
+	execQueueOnce.Do(func() {
+		execQueueInstance = &ExecQueue{
+			Workers:   positiveIntEnv("EXEC_CONCURRENCY", 4),
+			MaxQueued: positiveIntEnv("EXEC_QUEUE_SIZE", 32),
+			PerUser:   positiveIntEnv("EXEC_USER_QUEUE", 2),
+			PerIP:     positiveIntEnv("EXEC_IP_QUEUE", 32),
+			JobTTL:    time.Duration(positiveIntEnv("EXEC_JOB_TTL_SEC", 600)) * time.Second,
+			active:    map[string]int{},
+			ipCount:   map[string]int{},
+			waiting:   map[string][]*execTicket{},
+			jobs:      map[string]*ExecJob{},
+		}
+	})
+	return execQueueInstance
+}
+
+// enqueue は実行枠を要求します。すぐに空きがあれば割り当て済みのチケットを返す
+func (q *ExecQueue) enqueue(client ExecClient) (*execTicket, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	user := client.User
+	if q.active[user] >= q.PerUser {
+		return nil, errExecUserBusy
+	}
+	if client.IP != "" && q.PerIP > 0 && q.ipCount[client.IP] >= q.PerIP {
+		return nil, errExecIPBusy
+	}
+	t := &execTicket{user: user, ip: client.IP, ready: make(chan struct{})}
+	if q.running < q.Workers && q.queued == 0 {
+		q.count(t, 1)
+		q.grant(t)
+		return t, nil
+	}
+	if q.queued >= q.MaxQueued {
+		return nil, errExecQueueFull
+	}
+	q.count(t, 1)
+	q.queued++
+	if len(q.waiting[user]) == 0 {
+		q.users = append(q.users, user)
+	}
+	q.waiting[user] = append(q.waiting[user], t)
+	return t, nil
+}
+
+// count はユーザーごと・IP ごとの実行中 + 待機中の数を delta だけ増減します。q.mu を保持して呼ぶこと
+func (q *ExecQueue) count(t *execTicket, delta int) {
+	if q.active[t.user] += delta; q.active[t.user] <= 0 {
+		delete(q.active, t.user)
+	}
+	if t.ip == "" {
+		return
+	}
+	if q.ipCount[t.ip] += delta; q.ipCount[t.ip] <= 0 {
+		delete(q.ipCount, t.ip)
+	}
+}
+
+func (q *ExecQueue) grant(t *execTicket) {
+	q.running++
+	t.granted = true
+	close(t.ready)
+}
+
+// dispatch は空いた実行枠を待機中のユーザーに順番に割り当てます。q.mu を保持して呼ぶこと
+func (q *ExecQueue) dispatch() {
+	for q.running < q.Workers && len(q.users) > 0 {
+		user := q.users[0]
+		q.users = q.users[1:]
+		t := q.waiting[user][0]
+		if rest := q.waiting[user][1:]; len(rest) > 0 {
+			q.waiting[user] = rest
+			q.users = append(q.users, user)
+		} else {
+			delete(q.waiting, user)
+		}
+		q.queued--
+		q.grant(t)
+	}
+}
+
+// release は実行枠を返却するか、未割り当てなら待機列から取り除きます
+func (q *ExecQueue) release(t *execTicket) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if t.granted {
+		q.running--
+	} else {
+		list := q.waiting[t.user]
+		for i, w := range list {
+			if w == t {
+				list = append(list[:i:i], list[i+1:]...)
+				break
+			}
+		}
+		q.queued--
+		if len(list) > 0 {
+			q.waiting[t.user] = list
+		} else {
+			delete(q.waiting, t.user)
+			for i, u := range q.users {
+				if u == t.user {
+					q.users = append(q.users[:i:i], q.users[i+1:]...)
+					break
+				}
+			}
+		}
+	}
+	q.count(t, -1)
+	q.dispatch()
+}
+
+// position は待機中のチケットが何番目に実行されるか（1始まり）を返します。割り当て済みなら 0
+func (q *ExecQueue) position(t *execTicket) int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if t.granted {
+		return 0
+	}
+	pos := 0
+	for round := 0; ; round++ {
+		remaining := false
+		for _, user := range q.users {
+			list := q.waiting[user]
+			if round >= len(list) {
+				continue
+			}
+			remaining = true
+			pos++
+			if list[round] == t {
+				return pos
+			}
+		}
+		if !remaining {
+			return 0
+		}
+	}
+}
+
+// Acquire は実行枠が割り当てられるまで待ち、返却用の関数を返します（WebSocket の対話実行用）
+func (q *ExecQueue) Acquire(ctx context.Context, client ExecClient) (func(), error) {
+	t, err := q.enqueue(client)
+	if err != nil {
+		return nil, err
+	}
+	select {
+	case <-t.ready:
+		return func() { q.release(t) }, nil
+	case <-ctx.Done():
+		q.release(t)
+		return nil, ctx.Err()
+	}
+}
+
+// Submit は run をジョブとして待機列に入れ、実行枠が空き次第バックグラウンドで実行します
+func (q *ExecQueue) Submit(client ExecClient, run func() ResultPayload) (*ExecJob, error) {
+	t, err := q.enqueue(client)
+	if err != nil {
+		return nil, err
+	}
+	job := &ExecJob{
+		ID:      newExecJobID(),
+		queue:   q,
+		ticket:  t,
+		started: make(chan struct{}),
+		done:    make(chan struct{}),
+		status:  ExecJobQueued,
+	}
+
+	q.mu.Lock()
+	q.purgeJobs()
+	q.jobs[job.ID] = job
+	q.mu.Unlock()
+
+	go func() {
+		<-t.ready
+		job.mu.Lock()
+		job.status = ExecJobRunning
+		job.mu.Unlock()
+		close(job.started)
+
+		result := run()
+		// 結果を受け取ったクライアントがすぐ次を送れるよう、完了を通知する前に実行枠を返す
+		q.release(t)
+
+		job.mu.Lock()
+		job.status = ExecJobDone
+		job.result = &result
+		job.finishedAt = time.Now()
+		job.mu.Unlock()
+		close(job.done)
+	}()
+	return job, nil
+}
+
+// Job は ID に対応するジョブを返します。完了後 JobTTL を過ぎたものは見つからない
+func (q *ExecQueue) Job(id string) *ExecJob {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.purgeJobs()
+	return q.jobs[id]
+}
+
+// purgeJobs は保持期間を過ぎた完了済みジョブを削除します。q.mu を保持して呼ぶこと
+func (q *ExecQueue) purgeJobs() {
+	now := time.Now()
+	for id, job := range q.jobs {
+		job.mu.Lock()
+		expired := job.status == ExecJobDone && now.Sub(job.finishedAt) > q.JobTTL
+		job.mu.Unlock()
+		if expired {
+			delete(q.jobs, id)
+		}
+	}
+}
+
+// Wait はジョブが完了するか ctx が終わるまで待ちます。untilStarted なら実行開始の時点で戻る
+func (j *ExecJob) Wait(ctx context.Context, untilStarted bool) {
+	target := j.done
+	if untilStarted {
+		target = j.started
+	}
+	select {
+	case <-target:
+	case <-ctx.Done():
+	}
+}
+
+// Status はクライアントに返すジョブの状態を返します
+func (j *ExecJob) Status() ExecJobStatus {
+	j.mu.Lock()
+	status := ExecJobStatus{JobID: j.ID, Status: j.status, Result: j.result}
+	j.mu.Unlock()
+	if status.Status == ExecJobQueued {
+		status.Position = j.queue.position(j.ticket)
+	}
+	return status
+}
+
+func newExecJobID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
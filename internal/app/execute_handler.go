@@ -1,15 +1,16 @@
 package app
 
 import (
-	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"strconv"
 	"strings"
 	"time"
 )
@@ -27,59 +28,218 @@ func executeHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	dir, err := os.MkdirTemp("", "cpp-execution-")
+	opts, err := compileOptionsFromPayload(payload)
 	if err != nil {
-		log.Printf("ERROR: temp dir creation failed: %v", err)
-		sendErrorJSON(w, "server error: failed to create temp directory")
+		sendErrorJSON(w, err.Error())
+		return
+	}
+	if len(payload.TestCases) > maxJudgeCases {
+		sendErrorJSON(w, fmt.Sprintf("too many test cases (max %d)", maxJudgeCases))
 		return
 	}
-	defer os.RemoveAll(dir)
 
-	if err := os.WriteFile(filepath.Join(dir, "main.cpp"), []byte(payload.Code), 0666); err != nil {
-		log.Printf("ERROR: main.cpp write failed: %v", err)
-		sendErrorJSON(w, "server error: failed to write source file")
+	job, err := getExecQueue().Submit(execClient(r, payload.UserID), func() ResultPayload {
+		return executeCode(payload, opts)
+	})
+	if err != nil {
+		sendBusyJSON(w, err)
+		return
+	}
+	if payload.Async {
+		writeExecJobStatus(w, job.Status())
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	// すぐに実行枠が空かなければ job_id を返し、クライアントには status をポーリングしてもらう
+	waitCtx, cancel := context.WithTimeout(r.Context(), time.Duration(positiveIntEnv("EXEC_QUEUE_WAIT_SEC", 5))*time.Second)
+	job.Wait(waitCtx, true)
+	cancel()
+	if status := job.Status(); status.Status == ExecJobQueued {
+		writeExecJobStatus(w, status)
+		return
+	}
+	job.Wait(r.Context(), false)
+	if status := job.Status(); status.Result != nil {
+		writeJSON(w, status.Result)
+		return
+	}
+	writeExecJobStatus(w, job.Status())
+}
 
-	compileAndRunScript := "g++ -Wall /usr/src/app/main.cpp -o /usr/src/app/main.out && /usr/src/app/main.out"
-	//log.Printf("INFO: running Docker C++ execution")
-	runCmd := exec.CommandContext(ctx, "docker", "run",
-		"--rm",
-		"-i",
-		"--net=none",
-		"-v", fmt.Sprintf("%s:/usr/src/app", dir),
-		"gcc:latest",
-		"sh", "-c", compileAndRunScript,
-	)
-
-	if payload.Stdin != "" {
-		runCmd.Stdin = strings.NewReader(payload.Stdin)
-	}
-
-	var out bytes.Buffer
-	var stderr bytes.Buffer
-	runCmd.Stdout = &out
-	runCmd.Stderr = &stderr
-	err = runCmd.Run()
-
-	if ctx.Err() == context.DeadlineExceeded {
-		log.Println("ERROR: Docker run timed out")
-		sendErrorJSON(w, "execution timed out (10 seconds)")
+// executeStatusHandler は /api/execute/status/{id} でジョブの状態を返します。
+// ?wait=秒 を付けると、完了するかその秒数が経つまで待ってから返す（ロングポーリング）
+func executeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	job := getExecQueue().Job(r.PathValue("id"))
+	if job == nil {
+		writeJSONError(w, http.StatusNotFound, "job not found")
 		return
 	}
+	if wait, err := strconv.Atoi(r.URL.Query().Get("wait")); err == nil && wait > 0 {
+		if wait > maxExecStatusWait {
+			wait = maxExecStatusWait
+		}
+		ctx, cancel := context.WithTimeout(r.Context(), time.Duration(wait)*time.Second)
+		job.Wait(ctx, false)
+		cancel()
+	}
+	writeJSON(w, job.Status())
+}
+
+const maxExecStatusWait = 30
+
+// execClient は実行キューでの利用者を返します。公平性は user_id（無ければ接続元 IP）単位で、
+// user_id を変えて上限を回避されないよう接続元 IP ごとの上限も課す
+func execClient(r *http.Request, userID string) ExecClient {
+	ip := clientIP(r)
+	if userID = strings.TrimSpace(userID); userID != "" {
+		return ExecClient{User: "user:" + userID, IP: ip}
+	}
+	return ExecClient{User: "ip:" + ip, IP: ip}
+}
 
+// clientIP は接続元 IP を返します。リバースプロキシの背後では EXEC_TRUST_PROXY=true にすると、
+// プロキシが最後に追記した X-Forwarded-For のアドレスを使う
+func clientIP(r *http.Request) string {
+	if trust, _ := strconv.ParseBool(strings.TrimSpace(os.Getenv("EXEC_TRUST_PROXY"))); trust {
+		if forwarded := r.Header.Values("X-Forwarded-For"); len(forwarded) > 0 {
+			hops := strings.Split(forwarded[len(forwarded)-1], ",")
+			if ip := strings.TrimSpace(hops[len(hops)-1]); ip != "" {
+				return ip
+			}
+		}
+	}
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
-		sendErrorJSON(w, stderr.String())
-		return
+		host = r.RemoteAddr
+	}
+	return host
+}
+
+func writeExecJobStatus(w http.ResponseWriter, status ExecJobStatus) {
+	if status.Status != ExecJobDone {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
 	}
+	writeJSON(w, status)
+}
 
+// sendBusyJSON は実行キューが一杯のときに 429 を返します。本文は従来クライアントでも表示できる形式にする
+func sendBusyJSON(w http.ResponseWriter, err error) {
+	message := "server is busy. please try again in a moment"
+	if errors.Is(err, errExecUserBusy) {
+		message = "previous execution is still running. please wait for it to finish"
+	}
 	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Retry-After", "5")
+	w.WriteHeader(http.StatusTooManyRequests)
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
-	enc.Encode(ResultPayload{Result: out.String()})
+	enc.Encode(ResultPayload{Result: "エラー:\n" + message})
+}
+
+// executeCode はソースを準備してコンパイル・実行（テストケースがあればジャッジ）し、結果を返します
+func executeCode(payload CodePayload, opts CompileOptions) ResultPayload {
+	dir, err := prepareSourceDir(payload)
+	if err != nil {
+		log.Printf("ERROR: %v", err)
+		return errorResult("server error: failed to prepare source files")
+	}
+	defer os.RemoveAll(dir)
+
+	sandbox, err := getSandbox()
+	if err != nil {
+		log.Printf("ERROR(/api/execute): sandbox setup failed: %v", err)
+		return errorResult("server error: execution backend is not configured")
+	}
+
+	if len(payload.TestCases) > 0 {
+		result, err := judgeSubmission(sandbox, dir, opts, payload.TestCases, caseTimeLimit(payload.TimeLimitMs))
+		if errors.Is(err, errSandboxBusy) {
+			return errorResult("server is busy. please try again in a moment")
+		}
+		if err != nil {
+			log.Printf("ERROR(/api/execute): judge failed: %v", err)
+			return errorResult("server error: execution failed")
+		}
+		return result
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	compiled, err := sandbox.Compile(ctx, dir, opts)
+	if errors.Is(err, errSandboxBusy) {
+		return errorResult("server is busy. please try again in a moment")
+	}
+	if err != nil {
+		log.Printf("ERROR(/api/execute): compile failed: %v", err)
+		return errorResult("server error: execution failed")
+	}
+	diagnostics := parseCompilerDiagnostics(compiled.Stderr)
+	if compiled.TimedOut {
+		return ResultPayload{Result: "エラー:\ncompilation timed out", Phase: "compile"}
+	}
+	if compiled.ExitCode != 0 {
+		return ResultPayload{Result: "エラー:\n" + compiled.Stderr, Phase: "compile", Diagnostics: diagnostics}
+	}
+
+	run, err := runSandbox(context.Background(), sandbox, dir, payload.Stdin, sandboxLimitsFor(opts, 10*time.Second))
+	if err != nil {
+		log.Printf("ERROR(/api/execute): run failed: %v", err)
+		return errorResult("server error: execution failed")
+	}
+	result := ResultPayload{
+		Result:       run.Stdout,
+		Phase:        "run",
+		Diagnostics:  diagnostics,
+		ExitCode:     run.ExitCode,
+		Signal:       run.Signal,
+		WallTimeMs:   run.Duration.Milliseconds(),
+		PeakMemoryKB: run.PeakMemoryKB,
+		Truncated:    run.Truncated,
+	}
+	if opts.Sanitize {
+		result.Findings = parseSanitizerFindings(run.Stderr)
+	}
+	switch {
+	case run.TimedOut:
+		log.Println("ERROR: execution timed out")
+		result.Result = "エラー:\nexecution timed out (10 seconds)"
+	case run.Truncated:
+		result.Result = run.Stdout + "\n... (output truncated)"
+	case run.ExitCode != 0:
+		result.Result = "エラー:\n" + run.Stderr
+	}
+
+	return result
+}
+
+// prepareSourceDir は一時ディレクトリを作成してソースを書き込みます。呼び出し側で削除すること
+func prepareSourceDir(payload CodePayload) (string, error) {
+	dir, err := os.MkdirTemp("", "cpp-execution-")
+	if err != nil {
+		return "", fmt.Errorf("temp dir creation failed: %w", err)
+	}
+	files, err := projectFilesFromPayload(payload)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0777); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("%s dir creation failed: %w", name, err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0666); err != nil {
+			os.RemoveAll(dir)
+			return "", fmt.Errorf("%s write failed: %w", name, err)
+		}
+	}
+	return dir, nil
 }
 
 func sendErrorJSON(w http.ResponseWriter, errMsg string) {
@@ -87,5 +247,9 @@ func sendErrorJSON(w http.ResponseWriter, errMsg string) {
 	w.WriteHeader(http.StatusOK)
 	enc := json.NewEncoder(w)
 	enc.SetEscapeHTML(false)
-	enc.Encode(ResultPayload{Result: "エラー:\n" + errMsg})
+	enc.Encode(errorResult(errMsg))
+}
+
+func errorResult(errMsg string) ResultPayload {
+	return ResultPayload{Result: "エラー:\n" + errMsg}
 }
@@ -0,0 +1,223 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+const defaultInteractiveTimeLimit = 60 * time.Second
+
+// execWSWriter はプログラムの出力をチャンクごとに WebSocket へ送ります。
+// マルチバイト文字の途中で分割されないよう、末尾の不完全な UTF-8 は次の書き込みまで保留する
+type execWSWriter struct {
+	conn    *websocket.Conn
+	mu      *sync.Mutex
+	stream  string
+	pending []byte
+}
+
+func (w *execWSWriter) Write(p []byte) (int, error) {
+	data := append(w.pending, p...)
+	cut := len(data)
+	for i := len(data) - 1; i >= 0 && i >= len(data)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(data[i]) {
+			if !utf8.FullRune(data[i:]) {
+				cut = i
+			}
+			break
+		}
+	}
+	w.pending = append([]byte(nil), data[cut:]...)
+	if cut == 0 {
+		return len(p), nil
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if err := w.conn.WriteJSON(ExecWSServerMessage{Type: w.stream, Data: string(data[:cut])}); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush は保留中のバイトを送ります。プロセス終了後に呼び、不完全なまま残った UTF-8 は置換文字にする
+func (w *execWSWriter) Flush() error {
+	if len(w.pending) == 0 {
+		return nil
+	}
+	data := strings.ToValidUTF8(string(w.pending), "\uFFFD")
+	w.pending = nil
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.conn.WriteJSON(ExecWSServerMessage{Type: w.stream, Data: data})
+}
+
+func executeWSHandler(w http.ResponseWriter, r *http.Request) {
+	conn, err := wsUpgrader.Upgrade(w, r, nil)
+	if err != nil {
+		log.Printf("ERROR(WS execute): upgrade failed: %v", err)
+		return
+	}
+	defer conn.Close()
+
+	var writeMu sync.Mutex
+	send := func(msg ExecWSServerMessage) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		if err := conn.WriteJSON(msg); err != nil {
+			log.Printf("ERROR(WS execute): send failed: %v", err)
+		}
+	}
+
+	var start ExecWSClientMessage
+	if err := conn.ReadJSON(&start); err != nil || start.Type != "start" {
+		send(ExecWSServerMessage{Type: "error", Data: "first message must be {\"type\":\"start\", \"code\": ...}"})
+		return
+	}
+
+	opts, err := compileOptionsFromPayload(start.CodePayload)
+	if err != nil {
+		send(ExecWSServerMessage{Type: "error", Data: err.Error()})
+		return
+	}
+
+	// 対話実行も /api/execute と同じ実行枠を使う。待機中に空かなければ busy を返す
+	queueCtx, cancelQueue := context.WithTimeout(r.Context(), time.Duration(positiveIntEnv("EXEC_QUEUE_WAIT_SEC", 5))*time.Second)
+	release, err := getExecQueue().Acquire(queueCtx, execClient(r, start.UserID))
+	cancelQueue()
+	if err != nil {
+		send(ExecWSServerMessage{Type: "error", Data: "server is busy. please try again in a moment"})
+		return
+	}
+	defer release()
+
+	sandbox, err := getSandbox()
+	if err != nil {
+		log.Printf("ERROR(WS execute): sandbox setup failed: %v", err)
+		send(ExecWSServerMessage{Type: "error", Data: "server error: execution backend is not configured"})
+		return
+	}
+
+	dir, err := prepareSourceDir(start.CodePayload)
+	if err != nil {
+		log.Printf("ERROR(WS execute): %v", err)
+		send(ExecWSServerMessage{Type: "error", Data: "server error: failed to prepare source files"})
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	compileCtx, cancelCompile := context.WithTimeout(context.Background(), 30*time.Second)
+	compiled, err := sandbox.Compile(compileCtx, dir, opts)
+	cancelCompile()
+	if errors.Is(err, errSandboxBusy) {
+		send(ExecWSServerMessage{Type: "error", Data: "server is busy. please try again in a moment"})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR(WS execute): compile failed: %v", err)
+		send(ExecWSServerMessage{Type: "error", Data: "server error: execution failed"})
+		return
+	}
+	diagnostics := parseCompilerDiagnostics(compiled.Stderr)
+	if compiled.TimedOut || compiled.ExitCode != 0 {
+		result := &ResultPayload{Result: "エラー:\n" + compiled.Stderr, Phase: "compile", Diagnostics: diagnostics}
+		if compiled.TimedOut {
+			result.Result = "エラー:\ncompilation timed out"
+		}
+		send(ExecWSServerMessage{Type: "compiled", Diagnostics: diagnostics, Result: result})
+		return
+	}
+	send(ExecWSServerMessage{Type: "compiled", Diagnostics: diagnostics})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	stdinReader, stdinWriter := io.Pipe()
+	defer stdinReader.Close()
+	go func() {
+		defer cancel()
+		if start.Stdin != "" {
+			if _, err := stdinWriter.Write([]byte(start.Stdin)); err != nil {
+				return
+			}
+		}
+		for {
+			_, msgBytes, err := conn.ReadMessage()
+			if err != nil {
+				stdinWriter.CloseWithError(err)
+				return
+			}
+			var msg ExecWSClientMessage
+			if err := json.Unmarshal(msgBytes, &msg); err != nil {
+				continue
+			}
+			switch msg.Type {
+			case "stdin":
+				if _, err := stdinWriter.Write([]byte(msg.Data)); err != nil {
+					return
+				}
+			case "eof":
+				stdinWriter.Close()
+			case "kill":
+				return
+			}
+		}
+	}()
+
+	// サニタイザのレポートは終了時に解析するため、stderr を手元にも残す
+	var stderrCopy bytes.Buffer
+	stdoutWS := &execWSWriter{conn: conn, mu: &writeMu, stream: "stdout"}
+	stderrWS := &execWSWriter{conn: conn, mu: &writeMu, stream: "stderr"}
+	stdio := SandboxIO{
+		Stdin:        stdinReader,
+		Stdout:       stdoutWS,
+		Stderr:       io.MultiWriter(stderrWS, &stderrCopy),
+		LineBuffered: true,
+	}
+	limits := sandboxLimitsFor(opts, time.Duration(positiveIntEnv("EXEC_INTERACTIVE_TIMEOUT_SEC", int(defaultInteractiveTimeLimit.Seconds())))*time.Second)
+	run, err := sandbox.Run(ctx, dir, stdio, limits)
+	for _, ws := range []*execWSWriter{stdoutWS, stderrWS} {
+		if flushErr := ws.Flush(); flushErr != nil {
+			log.Printf("ERROR(WS execute): send failed: %v", flushErr)
+		}
+	}
+	if errors.Is(err, errSandboxBusy) {
+		send(ExecWSServerMessage{Type: "error", Data: "server is busy. please try again in a moment"})
+		return
+	}
+	if err != nil {
+		log.Printf("ERROR(WS execute): run failed: %v", err)
+		send(ExecWSServerMessage{Type: "error", Data: "server error: execution failed"})
+		return
+	}
+
+	result := &ResultPayload{
+		Phase:        "run",
+		ExitCode:     run.ExitCode,
+		Signal:       run.Signal,
+		WallTimeMs:   run.Duration.Milliseconds(),
+		PeakMemoryKB: run.PeakMemoryKB,
+		Truncated:    run.Truncated,
+	}
+	if opts.Sanitize {
+		result.Findings = parseSanitizerFindings(stderrCopy.String())
+	}
+	if run.TimedOut {
+		result.Result = "エラー:\nexecution timed out"
+	}
+	send(ExecWSServerMessage{Type: "exit", Result: result})
+	conn.WriteMessage(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""))
+}
@@ -0,0 +1,176 @@
+package app
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// GradeAttempt は採点1回分の記録（grade_attempts テーブル）。
+// task_progress は最高得点しか残らないため、研究用にすべての提出をここに残す
+type GradeAttempt struct {
+	ID             int64            `json:"id,omitempty"`
+	CreatedAt      string           `json:"created_at,omitempty"`
+	UserID         string           `json:"user_id"`
+	TaskID         string           `json:"task_id"`
+	Code           string           `json:"code"`
+	Output         string           `json:"output"`       // クライアントでの実行結果（提出時に学習者が見ていたもの）
+	JudgeResult    string           `json:"judge_result"` // サーバー側での再実行結果
+	Verdict        string           `json:"verdict"`
+	Passed         int              `json:"passed"`
+	Total          int              `json:"total"`
+	Cases          []CaseResult     `json:"cases"` // 非公開テストの入出力は含めない
+	Score          int              `json:"score"`
+	IsCleared      bool             `json:"is_cleared"`
+	RubricScores   []CriterionScore `json:"rubric_scores"`
+	Reason         string           `json:"reason"`
+	Improvement    string           `json:"improvement"`
+	Model          string           `json:"model"`                // AI 採点に使ったモデル（複数サンプルならカンマ区切り）
+	SampleScores   []int            `json:"sample_scores"`        // サンプルごとの総合点
+	ScoreSpread    int              `json:"score_spread"`         // サンプル間の総合点の差
+	NeedsReview    bool             `json:"needs_review"`         // サンプル間のばらつきが大きく、教員の確認が必要
+	Cached         bool             `json:"cached"`               // 採点キャッシュの結果を返した
+	Verified       bool             `json:"verified"`             // 課題レジストリのテストケースで採点した（false はクライアントが送った想定出力・実行結果に基づく）
+	Counterexample *Counterexample  `json:"counterexample"`       // 模範解答と出力が食い違ったランダム入力
+	StaticFindings []StaticFinding  `json:"static_checks"`        // 課題の静的チェックの結果
+	RegradeOf      *int64           `json:"regrade_of,omitempty"` // 教員の再採点による記録なら元の提出の ID
+	AILatencyMs    int64            `json:"ai_latency_ms"`        // AI フィードバックの所要時間
+	TotalLatencyMs int64            `json:"total_latency_ms"`     // テスト実行を含む採点全体の所要時間
+}
+
+// recordGradeAttempt は採点結果を grade_attempts に保存します。失敗しても採点結果の返却は止めない
+func recordGradeAttempt(attempt GradeAttempt) {
+	if supabaseClient == nil || attempt.UserID == "" || attempt.TaskID == "" {
+		return
+	}
+	if attempt.SampleScores == nil {
+		attempt.SampleScores = []int{}
+	}
+	var result interface{}
+	if err := supabaseClient.DB.From("grade_attempts").Insert(attempt).Execute(&result); err != nil {
+		log.Printf("ERROR: grade attempt insert failed: user_id=%s task_id=%s err=%v", attempt.UserID, attempt.TaskID, err)
+	}
+}
+
+// fetchGradeAttempts は提出履歴を新しい順に返します。userID・taskID が空なら絞り込まない
+func fetchGradeAttempts(userID, taskID string, needsReviewOnly bool, limit int) ([]GradeAttempt, error) {
+	builder := supabaseClient.DB.From("grade_attempts").Select("*").OrderBy("created_at", "desc").Limit(limit)
+	if userID != "" {
+		builder.Eq("user_id", userID)
+	}
+	if taskID != "" {
+		builder.Eq("task_id", taskID)
+	}
+	if needsReviewOnly {
+		builder.Eq("needs_review", "true")
+	}
+	var attempts []GradeAttempt
+	if err := builder.Execute(&attempts); err != nil {
+		return nil, err
+	}
+	if attempts == nil {
+		attempts = []GradeAttempt{}
+	}
+	return attempts, nil
+}
+
+// fetchGradeAttempt は ID を指定して提出を1件返します
+func fetchGradeAttempt(id int64) (*GradeAttempt, error) {
+	var attempts []GradeAttempt
+	if err := supabaseClient.DB.From("grade_attempts").Select("*").Eq("id", strconv.FormatInt(id, 10)).Execute(&attempts); err != nil {
+		return nil, err
+	}
+	if len(attempts) == 0 {
+		return nil, nil
+	}
+	return &attempts[0], nil
+}
+
+// gradingContextForChat はチャットの AI に渡す、その課題の直近の採点結果の要約を返します（無ければ空文字列）
+func gradingContextForChat(userID, taskID string) string {
+	if supabaseClient == nil || userID == "" || taskID == "" {
+		return ""
+	}
+	attempts, err := fetchGradeAttempts(userID, taskID, false, 1)
+	if err != nil {
+		log.Printf("WARNING: latest grade attempt fetch failed: user_id=%s task_id=%s err=%v", userID, taskID, err)
+		return ""
+	}
+	if len(attempts) == 0 {
+		return ""
+	}
+	latest := attempts[0]
+	text := fmt.Sprintf("score=%d verdict=%s passed=%d/%d", latest.Score, latest.Verdict, latest.Passed, latest.Total)
+	if latest.Counterexample != nil {
+		text += "\n\n[Counterexample (reference solution vs. submitted code)]\n" + formatCounterexample(latest.Counterexample)
+	}
+	return text
+}
+
+func gradeAttemptsLimit(raw string, defaultLimit int, maxLimit int) int {
+	limit := defaultLimit
+	if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+		limit = parsed
+	}
+	return min(limit, maxLimit)
+}
+
+// gradeHistoryHandler は /api/grade/history?user_id=&task_id= で学習者自身の提出履歴を返します
+func gradeHistoryHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	q := r.URL.Query()
+	userID := strings.TrimSpace(q.Get("user_id"))
+	taskID := strings.TrimSpace(q.Get("task_id"))
+	if userID == "" {
+		writeJSONError(w, http.StatusBadRequest, "user_id is required")
+		return
+	}
+	if supabaseClient == nil {
+		writeJSON(w, map[string]interface{}{"attempts": []GradeAttempt{}})
+		return
+	}
+
+	attempts, err := fetchGradeAttempts(userID, taskID, false, gradeAttemptsLimit(q.Get("limit"), 50, 200))
+	if err != nil {
+		log.Printf("ERROR: grade history fetch failed: user_id=%s task_id=%s err=%v", userID, taskID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch grade history")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"attempts": attempts})
+}
+
+// adminGradeAttemptsHandler は /api/admin/grade-attempts で提出履歴を返します（participant_id / user_id / task_id で絞り込み）。
+// needs_review=true で、合議採点のばらつきが大きく確認が必要な提出だけを返す
+func adminGradeAttemptsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodGet) || !requireAdmin(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	participantID := strings.TrimSpace(q.Get("participant_id"))
+	userID := strings.TrimSpace(q.Get("user_id"))
+	taskID := strings.TrimSpace(q.Get("task_id"))
+	if participantID != "" && userID == "" {
+		profile, err := fetchAdminProfileByParticipantID(participantID)
+		if err != nil {
+			log.Printf("ERROR: admin profile lookup failed: participant_id=%s err=%v", participantID, err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to find profile")
+			return
+		}
+		userID = profile.ID
+	}
+
+	needsReview := q.Get("needs_review") == "true"
+	attempts, err := fetchGradeAttempts(userID, taskID, needsReview, gradeAttemptsLimit(q.Get("limit"), 500, 5000))
+	if err != nil {
+		log.Printf("ERROR: admin grade attempts fetch failed: user_id=%s task_id=%s err=%v", userID, taskID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch grade attempts")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"grade_attempts": attempts})
+}
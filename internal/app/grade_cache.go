@@ -0,0 +1,220 @@
+package app
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+var (
+	gradeCacheInstance *GradeCache
+	gradeCacheOnce     sync.Once
+)
+
+// GradeCache は採点結果を一定時間保持します。
+// キーに課題のルーブリック・テストケースと採点プロンプトを含めるため、それらが変わると自然に無効になる
+type GradeCache struct {
+	TTL        time.Duration
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]gradeCacheEntry
+}
+
+type gradeCacheEntry struct {
+	outcome  GradeOutcome
+	storedAt time.Time
+}
+
+// getGradeCache は GRADE_CACHE_TTL_SEC（デフォルト 3600）・GRADE_CACHE_SIZE（デフォルト 1000）で設定した採点キャッシュを返します。
+// GRADE_CACHE_DISABLED=true なら nil（キャッシュしない）
+func getGradeCache() *GradeCache {
+	gradeCacheOnce.Do(func() {
+		if os.Getenv("GRADE_CACHE_DISABLED") == "true" {
+			return
+		}
+		gradeCacheInstance = &GradeCache{
+			TTL:        time.Duration(positiveIntEnv("GRADE_CACHE_TTL_SEC", 3600)) * time.Second,
+			MaxEntries: positiveIntEnv("GRADE_CACHE_SIZE", 1000),
+			entries:    map[string]gradeCacheEntry{},
+		}
+	})
+	return gradeCacheInstance
+}
+
+func (c *GradeCache) Get(key string) (GradeOutcome, bool) {
+	if c == nil {
+		return GradeOutcome{}, false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[key]
+	if !ok {
+		return GradeOutcome{}, false
+	}
+	if time.Since(entry.storedAt) > c.TTL {
+		delete(c.entries, key)
+		return GradeOutcome{}, false
+	}
+	return entry.outcome, true
+}
+
+func (c *GradeCache) Put(key string, outcome GradeOutcome) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= c.MaxEntries {
+		c.evictLocked()
+	}
+	c.entries[key] = gradeCacheEntry{outcome: outcome, storedAt: time.Now()}
+}
+
+// evictLocked は期限切れのエントリを捨て、それでも満杯なら最も古いエントリを捨てます
+func (c *GradeCache) evictLocked() {
+	oldestKey := ""
+	var oldest time.Time
+	for key, entry := range c.entries {
+		if time.Since(entry.storedAt) > c.TTL {
+			delete(c.entries, key)
+			continue
+		}
+		if oldestKey == "" || entry.storedAt.Before(oldest) {
+			oldestKey, oldest = key, entry.storedAt
+		}
+	}
+	if len(c.entries) >= c.MaxEntries && oldestKey != "" {
+		delete(c.entries, oldestKey)
+	}
+}
+
+// gradeCacheKey は課題の採点条件（ルーブリック・テストケース・静的チェック・採点プロンプト・採点モデル）と、
+// 空白とコメントを除いたコード・出力からキャッシュのキーを作ります
+func gradeCacheKey(task *Task, code string, output string) string {
+	conditions, _ := json.Marshal(struct {
+		ID             string
+		Description    string
+		ExpectedOutput string
+		Tests          []TestCase
+		TimeLimitMs    int
+		Reference      string
+		RandomTests    *RandomTestSpec
+		StaticChecks   []StaticCheck
+		Rubric         []RubricCriterion
+		Prompt         string
+		Samples        int
+		Models         string
+	}{task.ID, task.Description, task.ExpectedOutput, task.GradingTests(), task.TimeLimitMs, task.ReferenceSolution, task.RandomTests,
+		task.StaticChecks, task.GradingRubric(), gradeSystemPrompt, gradeSampleCount(), gradeModelSpec()})
+
+	h := sha256.New()
+	for _, part := range []string{string(conditions), normalizeSourceForCache(code), strings.TrimSpace(output)} {
+		h.Write([]byte(part))
+		h.Write([]byte{0})
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// normalizeSourceForCache は C++ のコードからコメントを取り除き、連続する空白（改行・インデントを含む）を1つにまとめます。
+// a + ++b と a++ + b のようにトークンの区切りが意味を持つため、空白があった位置には区切りを1つ残す。
+// 文字列・文字リテラルの中身とプリプロセッサ行の改行はそのまま残す
+func normalizeSourceForCache(code string) string {
+	var b strings.Builder
+	src := []rune(code)
+	pendingSpace := false
+	lineStart := true  // 行頭（空白以外の文字をまだ出力していない）
+	directive := false // プリプロセッサ行の途中
+	var last rune
+
+	emit := func(r rune) {
+		if pendingSpace && last != 0 && last != '\n' {
+			b.WriteRune(' ')
+		}
+		pendingSpace = false
+		b.WriteRune(r)
+		last = r
+	}
+	newline := func() {
+		if directive {
+			b.WriteRune('\n')
+			last = '\n'
+			pendingSpace = false
+		} else {
+			pendingSpace = true
+		}
+		directive = false
+		lineStart = true
+	}
+
+	for i := 0; i < len(src); i++ {
+		r := src[i]
+		switch {
+		case r == '/' && i+1 < len(src) && src[i+1] == '/':
+			for i+1 < len(src) && src[i+1] != '\n' {
+				i++
+			}
+		case r == '/' && i+1 < len(src) && src[i+1] == '*':
+			i += 2
+			for i+1 < len(src) && !(src[i] == '*' && src[i+1] == '/') {
+				if src[i] == '\n' && directive {
+					newline()
+				}
+				i++
+			}
+			i++
+			pendingSpace = true
+		case r == '"' || r == '\'' && !isDigitSeparator(src, i):
+			lineStart = false
+			emit(r)
+			for i+1 < len(src) {
+				i++
+				b.WriteRune(src[i])
+				if src[i] == '\\' && i+1 < len(src) {
+					i++
+					b.WriteRune(src[i])
+					continue
+				}
+				if src[i] == r || src[i] == '\n' {
+					break
+				}
+			}
+			last = r
+		case r == '\\' && i+1 < len(src) && src[i+1] == '\n':
+			// 行継続はプリプロセッサ行を続ける
+			i++
+			pendingSpace = true
+		case r == '\n':
+			newline()
+		case r == ' ' || r == '\t' || r == '\r' || r == '\f' || r == '\v':
+			pendingSpace = true
+		default:
+			if lineStart {
+				directive = r == '#'
+				lineStart = false
+			}
+			emit(r)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// isDigitSeparator は src[i] の ' が 1'000 のような数値リテラルの桁区切り（C++14）かどうかを返します
+func isDigitSeparator(src []rune, i int) bool {
+	if i == 0 || i+1 >= len(src) || !isWordRune(src[i-1]) || !isWordRune(src[i+1]) {
+		return false
+	}
+	start := i
+	for start > 0 && (isWordRune(src[start-1]) || src[start-1] == '\'' || src[start-1] == '.') {
+		start--
+	}
+	return src[start] >= '0' && src[start] <= '9' || src[start] == '.'
+}
+
+func isWordRune(r rune) bool {
+	return r == '_' || r >= '0' && r <= '9' || r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r > 127
+}
@@ -0,0 +1,190 @@
+package app
+
+import (
+	"context"
+	"log"
+	"os"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const maxGradeSamples = 7
+
+// GradeConsensus は複数サンプルで AI に採点させた結果をまとめたもの
+type GradeConsensus struct {
+	Response     GradeResponse // 観点ごとの中央値と、総合点が中央値に最も近いサンプルの講評
+	Models       []string      // 採点に成功したサンプルのモデル
+	SampleScores []int         // サンプルごとの総合点（テスト観点を含む）
+	Spread       int           // サンプル間の総合点の最大と最小の差
+	NeedsReview  bool          // Spread が GRADE_REVIEW_SPREAD 以上なら教員の確認対象にする
+}
+
+// gradeSampleCount は1回の採点で AI に判定させる回数（GRADE_SAMPLES、デフォルト 1）を返します
+func gradeSampleCount() int {
+	return min(positiveIntEnv("GRADE_SAMPLES", 1), maxGradeSamples)
+}
+
+// gradeSampleProviders はサンプルごとのプロバイダを返します。
+// GRADE_SAMPLE_PROVIDERS（例: "openai/gpt-4o-mini,anthropic/claude-sonnet-4-5"）があれば順番に割り当て、
+// 無ければすべて GRADE_AI_PROVIDER のプロバイダを使う
+func gradeSampleProviders(n int) ([]ChatProvider, error) {
+	var specs []string
+	for _, spec := range strings.Split(os.Getenv("GRADE_SAMPLE_PROVIDERS"), ",") {
+		if spec = strings.TrimSpace(spec); spec != "" {
+			specs = append(specs, spec)
+		}
+	}
+
+	var pool []ChatProvider
+	if len(specs) == 0 {
+		provider, err := getAIProvider(AIPurposeGrade)
+		if err != nil {
+			return nil, err
+		}
+		pool = []ChatProvider{provider}
+	}
+	for _, spec := range specs {
+		name, model, _ := strings.Cut(spec, "/")
+		provider, err := newAIProvider(AIPurposeGrade, strings.ToLower(strings.TrimSpace(name)), strings.TrimSpace(model))
+		if err != nil {
+			return nil, err
+		}
+		pool = append(pool, provider)
+	}
+
+	providers := make([]ChatProvider, n)
+	for i := range providers {
+		providers[i] = pool[i%len(pool)]
+	}
+	return providers, nil
+}
+
+// gradeModelSpec は採点に使うプロバイダとモデルを "<provider>/<model>" のカンマ区切りで返します（採点キャッシュのキー用）
+func gradeModelSpec() string {
+	providers, err := gradeSampleProviders(gradeSampleCount())
+	if err != nil {
+		return ""
+	}
+	names := make([]string, len(providers))
+	for i, p := range providers {
+		names[i] = aiModelName(p)
+	}
+	return strings.Join(names, ",")
+}
+
+// requestGradeFeedback は AI にルーブリックの AI 観点の採点とフィードバックを依頼します
+func requestGradeFeedback(provider ChatProvider, userMessage string) (GradeResponse, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	var res GradeResponse
+	_, err := generateStructured(ctx, provider, gradeSystemPrompt, []OpenAIMessage{{Role: "user", Content: userMessage}}, gradeResponseSchema, &res)
+	return res, err
+}
+
+// requestGradeConsensus は GRADE_SAMPLES 回並行して AI に採点させ、観点ごとの中央値で合議します。
+// 一部のサンプルが失敗しても、成功したサンプルだけで合議する（すべて失敗した場合のみエラー）
+func requestGradeConsensus(userMessage string, rubric []RubricCriterion, cases []CaseResult, findings []StaticFinding, compileError bool) (GradeConsensus, error) {
+	providers, err := gradeSampleProviders(gradeSampleCount())
+	if err != nil {
+		return GradeConsensus{}, err
+	}
+
+	responses := make([]GradeResponse, len(providers))
+	errs := make([]error, len(providers))
+	var wg sync.WaitGroup
+	for i, provider := range providers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			responses[i], errs[i] = requestGradeFeedback(provider, userMessage)
+		}()
+	}
+	wg.Wait()
+
+	var consensus GradeConsensus
+	var samples []GradeResponse
+	for i, res := range responses {
+		if errs[i] != nil {
+			log.Printf("WARNING: grade sample %d/%d failed: model=%s err=%v", i+1, len(providers), aiModelName(providers[i]), errs[i])
+			continue
+		}
+		_, total := scoreRubric(rubric, cases, findings, compileError, res.Criteria)
+		samples = append(samples, res)
+		consensus.Models = append(consensus.Models, aiModelName(providers[i]))
+		consensus.SampleScores = append(consensus.SampleScores, total)
+	}
+	if len(samples) == 0 {
+		return consensus, errs[0]
+	}
+
+	// 講評は総合点が中央値に最も近いサンプルのものを使う
+	medianTotal := medianInt(consensus.SampleScores)
+	representative := 0
+	for i, total := range consensus.SampleScores {
+		if absInt(total-medianTotal) < absInt(consensus.SampleScores[representative]-medianTotal) {
+			representative = i
+		}
+	}
+	consensus.Response = GradeResponse{
+		Criteria:    medianCriteria(rubric, samples, representative),
+		Reason:      samples[representative].Reason,
+		Improvement: samples[representative].Improvement,
+	}
+
+	consensus.Spread = slices.Max(consensus.SampleScores) - slices.Min(consensus.SampleScores)
+	consensus.NeedsReview = len(samples) > 1 && consensus.Spread >= positiveIntEnv("GRADE_REVIEW_SPREAD", 15)
+	if consensus.NeedsReview {
+		log.Printf("WARNING: grade samples disagree: scores=%v spread=%d", consensus.SampleScores, consensus.Spread)
+	}
+	return consensus, nil
+}
+
+// medianCriteria は AI 観点ごとにサンプルの得点の中央値をとります。コメントは代表サンプルのもの
+func medianCriteria(rubric []RubricCriterion, samples []GradeResponse, representative int) []AICriterionScore {
+	var criteria []AICriterionScore
+	for _, c := range rubric {
+		if c.Kind != RubricKindAI {
+			continue
+		}
+		var scores []int
+		comment := ""
+		for i, sample := range samples {
+			for _, s := range sample.Criteria {
+				if s.ID != c.ID {
+					continue
+				}
+				scores = append(scores, s.Score)
+				if i == representative || comment == "" {
+					comment = s.Comment
+				}
+			}
+		}
+		if len(scores) == 0 {
+			continue
+		}
+		criteria = append(criteria, AICriterionScore{ID: c.ID, Score: medianInt(scores), Comment: comment})
+	}
+	return criteria
+}
+
+// medianInt は中央値を返します（偶数個なら中央の2つの平均を切り捨て）
+func medianInt(values []int) int {
+	sorted := append([]int(nil), values...)
+	sort.Ints(sorted)
+	mid := len(sorted) / 2
+	if len(sorted)%2 == 0 {
+		return (sorted[mid-1] + sorted[mid]) / 2
+	}
+	return sorted[mid]
+}
+
+func absInt(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
@@ -1,10 +1,15 @@
 package app
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
 func gradeHandler(w http.ResponseWriter, r *http.Request) {
@@ -18,74 +23,77 @@ func gradeHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Invalid JSON", http.StatusBadRequest)
 		return
 	}
+	startedAt := time.Now()
 
-	userMessage := fmt.Sprintf(
-		"縲占ｪｲ鬘後曾n%s\n\n縲先Φ螳壼・蜉帙曾n%s\n\n縲先署蜃ｺ繧ｳ繝ｼ繝峨曾n%s\n\n縲仙ｮ滄圀縺ｮ螳溯｡悟・蜉帙曾n%s",
-		p.TaskDesc, p.ExpectedOutput, p.Code, p.Output,
-	)
-
-	aiResponseStr, err := callOpenAI(gradeSystemPrompt, userMessage, false)
-	if err != nil {
-		http.Error(w, "AI Error: "+err.Error(), http.StatusInternalServerError)
-		return
-	}
+	task, registered := resolveGradeTask(p)
+	tests := task.GradingTests()
+	// 課題レジストリのテストケースで採点したものだけを verified とする。
+	// それ以外はクライアントが送った想定出力・実行結果に基づく採点で、grade_attempts とレスポンスで区別できるようにする
+	verified := registered && len(tests) > 0
 
-	aiResponseStr = cleanJSONString(aiResponseStr)
-	var gradeRes GradeResponse
-	if err := json.Unmarshal([]byte(aiResponseStr), &gradeRes); err != nil {
-		log.Printf("ERROR: grade JSON parse failed: %v\nRaw: %s", err, aiResponseStr)
-		http.Error(w, "AI response parse error", http.StatusInternalServerError)
-		return
+	// 同じ課題・同じコード（空白とコメントを除く）・同じ出力の再提出はキャッシュした採点結果を返す
+	cacheKey := gradeCacheKey(task, p.Code, p.Output)
+	outcome, cached := getGradeCache().Get(cacheKey)
+	if !cached {
+		var err error
+		outcome, err = evaluateSubmission(r.Context(), execClient(r, p.UserID), p.Code, p.Output, task, tests)
+		if isExecBusyError(err) {
+			writeJSONError(w, http.StatusTooManyRequests, "server is busy. please try again in a moment")
+			return
+		}
+		if err != nil {
+			log.Printf("ERROR(/api/grade): hidden test run failed: task_id=%s err=%v", p.TaskID, err)
+			http.Error(w, "Execution failed", http.StatusInternalServerError)
+			return
+		}
+		// AI が使えずテスト結果だけで採点した場合は、次の提出で採点し直せるようキャッシュしない
+		if !outcome.AIFailed {
+			getGradeCache().Put(cacheKey, outcome)
+		}
 	}
+	judged, gradeRes, criteria, consensus := outcome.Judged, outcome.Response, outcome.Criteria, outcome.Consensus
+	passed := countPassed(judged.Cases)
 
 	currentScore := gradeRes.Score
-	bonusLove := 0
-	isNewRecord := false
-
-	if supabaseClient != nil && p.UserID != "" && p.TaskID != "" {
-		var existing []UserTaskProgress
-		supabaseClient.DB.From("task_progress").Select("*").
-			Eq("user_id", p.UserID).Eq("task_id", p.TaskID).Execute(&existing)
-
-		if len(existing) > 0 {
-			prev := existing[0]
-			if currentScore > prev.HighScore {
-				isNewRecord = true
-				if currentScore >= 80 {
-					bonusLove = 5
-				}
-				updateData := map[string]interface{}{
-					"high_score": currentScore,
-					"is_cleared": currentScore >= 80,
-				}
-				var updateResult interface{}
-				supabaseClient.DB.From("task_progress").Update(updateData).
-					Eq("user_id", p.UserID).Eq("task_id", p.TaskID).Execute(&updateResult)
-			}
-		} else {
-			if currentScore >= 80 {
-				bonusLove = 5
-			}
-			newData := map[string]interface{}{
-				"user_id":    p.UserID,
-				"task_id":    p.TaskID,
-				"high_score": currentScore,
-				"is_cleared": currentScore >= 80,
-			}
-			var insertResult interface{}
-			inErr := supabaseClient.DB.From("task_progress").Insert(newData).Execute(&insertResult)
-			if inErr != nil {
-				log.Printf("ERROR: Supabase Insert failed: %v", inErr)
-			}
+	var progress TaskProgressUpdate
+	if supabaseClient != nil && p.UserID != "" && p.TaskID != "" && (verified || !gradeRequireVerified()) {
+		var err error
+		progress, err = recordTaskProgress(p.UserID, p.TaskID, currentScore, task.PassThreshold, criteria)
+		if err != nil {
+			log.Printf("ERROR: task progress update failed: user_id=%s task_id=%s err=%v", p.UserID, p.TaskID, err)
+			progress = TaskProgressUpdate{}
 		}
 	}
 
+	attempt := outcome.Attempt(p.UserID, p.TaskID, p.Code, p.Output, task)
+	attempt.Cached = cached
+	attempt.Verified = verified
+	if cached {
+		attempt.AILatencyMs = 0
+	}
+	attempt.TotalLatencyMs = time.Since(startedAt).Milliseconds()
+	recordGradeAttempt(attempt)
+
 	responseMap := map[string]interface{}{
-		"score":         gradeRes.Score,
-		"reason":        gradeRes.Reason,
-		"improvement":   gradeRes.Improvement,
-		"bonus_love":    bonusLove,
-		"is_new_record": isNewRecord,
+		"score":          gradeRes.Score,
+		"criteria":       criteria,
+		"verdict":        judged.Verdict,
+		"passed":         passed,
+		"total":          outcome.Total,
+		"cases":          hideCaseDetails(judged.Cases),
+		"diagnostics":    judged.Diagnostics,
+		"reason":         gradeRes.Reason,
+		"improvement":    gradeRes.Improvement,
+		"sample_scores":  consensus.SampleScores,
+		"score_spread":   consensus.Spread,
+		"counterexample": outcome.Counterexample,
+		"static_checks":  outcome.StaticFindings,
+		"cached":         cached,
+		"verified":       verified,
+		"pass_threshold": task.PassThreshold,
+		"bonus_love":     progress.BonusLove,
+		"is_new_record":  progress.IsNewRecord,
+		"love_level":     progress.LoveLevel,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -93,3 +101,142 @@ func gradeHandler(w http.ResponseWriter, r *http.Request) {
 	enc.SetEscapeHTML(false)
 	enc.Encode(responseMap)
 }
+
+// GradeOutcome は提出1件の採点結果（ユーザーに依存しない部分。採点キャッシュに保存する）
+type GradeOutcome struct {
+	Judged         ResultPayload
+	Total          int             // テストケース数（ランダム入力のケースを含む）
+	Counterexample *Counterexample // 模範解答と出力が食い違った最初のランダム入力
+	StaticFindings []StaticFinding // 課題の静的チェックの結果
+	Response       GradeResponse
+	Criteria       []CriterionScore
+	Consensus      GradeConsensus
+	AILatency      time.Duration
+	AIFailed       bool // AI の採点に失敗し、テスト観点だけで採点した
+}
+
+// evaluateSubmission はテストケースで再実行してから、AI にルーブリックの AI 観点を採点させます。
+// output はクライアントでの実行結果で、テストケースが無い場合だけ AI に参考として渡す
+func evaluateSubmission(ctx context.Context, client ExecClient, code string, output string, task *Task, tests []TestCase) (GradeOutcome, error) {
+	// 模範解答があれば、ランダム入力での模範解答の出力を想定出力とするケースも加える
+	randomCases, err := differentialCases(ctx, task)
+	if isExecBusyError(err) {
+		return GradeOutcome{}, err
+	}
+	if err != nil {
+		log.Printf("ERROR: differential test setup failed: task_id=%s err=%v", task.ID, err)
+	}
+	allTests := append(append([]TestCase(nil), tests...), randomCases...)
+
+	// 正しさはクライアントが送る出力ではなく、サーバー側での再実行結果で判定する
+	judged, err := runGradeTests(ctx, client, code, allTests, caseTimeLimit(task.TimeLimitMs))
+	if err != nil {
+		return GradeOutcome{}, err
+	}
+	counterexample := firstCounterexample(judged.Cases, randomCases)
+	findings := runStaticChecks(code, task.StaticChecks)
+	rubric := task.GradingRubric()
+
+	// AI はルーブリックのうち AI 観点の採点と、採点理由・改善点のみを担当する
+	userMessage := fmt.Sprintf(
+		"【課題】\n%s\n\n【想定出力】\n%s\n\n【提出コード】\n%s\n\n【自動テストの結果】\n%s\n\n【評価観点】\n%s",
+		task.Description, task.ExpectedOutput, code, formatJudgeSummary(judged), formatRubricForPrompt(rubric),
+	)
+	if len(allTests) == 0 {
+		userMessage += "\n\n【実行結果（学習者の環境での出力。サーバー側では検証していない）】\n" + output
+	}
+	if len(randomCases) > 0 {
+		userMessage += "\n\n【模範解答との比較（ランダム入力の反例）】\n" + formatCounterexample(counterexample)
+	}
+	if len(findings) > 0 {
+		userMessage += "\n\n【静的チェック（使うべき・使ってはいけない構文）】\n" + formatStaticFindings(findings)
+	}
+
+	compileError := judged.Verdict == VerdictCompileError
+	aiStartedAt := time.Now()
+	consensus, err := requestGradeConsensus(userMessage, rubric, judged.Cases, findings, compileError)
+	outcome := GradeOutcome{
+		Judged:         judged,
+		Total:          len(allTests),
+		Counterexample: counterexample,
+		StaticFindings: findings,
+		Consensus:      consensus,
+		Response:       consensus.Response,
+		AILatency:      time.Since(aiStartedAt),
+	}
+	if err != nil {
+		// テスト観点の点数はすでに確定しているので、AI が使えなくても採点結果は返す
+		log.Printf("ERROR: grade AI feedback failed: %v", err)
+		outcome.AIFailed = true
+		outcome.Response = GradeResponse{Reason: fmt.Sprintf("自動テストの結果: %s", judged.Result)}
+	}
+
+	outcome.Criteria, outcome.Response.Score = scoreRubric(rubric, judged.Cases, findings, compileError, outcome.Response.Criteria)
+	normalizeGradeResponse(&outcome.Response)
+	return outcome, nil
+}
+
+// Attempt は採点結果を grade_attempts に保存する形にします
+func (o GradeOutcome) Attempt(userID, taskID, code, output string, task *Task) GradeAttempt {
+	return GradeAttempt{
+		UserID:         userID,
+		TaskID:         taskID,
+		Code:           code,
+		Output:         output,
+		JudgeResult:    o.Judged.Result,
+		Verdict:        o.Judged.Verdict,
+		Passed:         countPassed(o.Judged.Cases),
+		Total:          o.Total,
+		Cases:          hideCaseDetails(o.Judged.Cases),
+		Score:          o.Response.Score,
+		IsCleared:      o.Response.Score >= task.PassThreshold,
+		RubricScores:   o.Criteria,
+		Reason:         o.Response.Reason,
+		Improvement:    o.Response.Improvement,
+		Model:          strings.Join(o.Consensus.Models, ","),
+		SampleScores:   o.Consensus.SampleScores,
+		ScoreSpread:    o.Consensus.Spread,
+		NeedsReview:    o.Consensus.NeedsReview,
+		AILatencyMs:    o.AILatency.Milliseconds(),
+		Counterexample: o.Counterexample,
+		StaticFindings: o.StaticFindings,
+	}
+}
+
+// resolveGradeTask は task_id に対応する課題を課題レジストリから引きます。登録されていれば true を返す。
+// 登録されていない課題は、クライアントが送った課題文と想定出力（標準入力なしの1ケース）で採点し、
+// 想定出力も無ければ提出された実行結果を参考に AI が採点する
+func resolveGradeTask(p GradePayload) (*Task, bool) {
+	if task := getTaskRegistry().Get(p.TaskID); task != nil {
+		return task, true
+	}
+	log.Printf("WARNING: task_id=%s is not in the task registry, grading as unverified against client-supplied task data", p.TaskID)
+	task := &Task{ID: p.TaskID, Description: p.TaskDesc, ExpectedOutput: p.ExpectedOutput, PassThreshold: defaultPassThreshold}
+	if strings.TrimSpace(p.ExpectedOutput) != "" {
+		task.Tests = []TestCase{{Name: "expected_output", ExpectedOutput: p.ExpectedOutput}}
+	} else {
+		task.Rubric = untestedRubric(gradeStylePoints())
+	}
+	return task, false
+}
+
+// gradeRequireVerified は GRADE_REQUIRE_VERIFIED=true のとき true を返します。
+// true なら verified でない採点（課題レジストリに無い課題など）は task_progress・ラブ度ボーナスに反映しない。
+// デフォルトは false で、従来どおりすべての採点を反映する
+func gradeRequireVerified() bool {
+	raw := strings.TrimSpace(os.Getenv("GRADE_REQUIRE_VERIFIED"))
+	if raw == "" {
+		return false
+	}
+	required, err := strconv.ParseBool(raw)
+	if err != nil {
+		log.Printf("WARNING: GRADE_REQUIRE_VERIFIED must be true or false. Using default false.")
+		return false
+	}
+	return required
+}
+
+// gradeStylePoints は 100 点のうちスタイル点（AI 評価）に割り当てる点数を返します
+func gradeStylePoints() int {
+	return min(positiveIntEnv("GRADE_STYLE_POINTS", 20), 100)
+}
@@ -0,0 +1,344 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+)
+
+const regradeConcurrency = 4
+
+type adminGradeOverrideRequest struct {
+	UserID        string `json:"user_id"`
+	ParticipantID string `json:"participant_id"`
+	TaskID        string `json:"task_id"`
+	Score         int    `json:"score"`
+	IsCleared     *bool  `json:"is_cleared"` // 省略時は課題の合格点で判定
+	Reason        string `json:"reason"`
+	ChangedBy     string `json:"changed_by"`
+}
+
+type adminClearOverrideRequest struct {
+	UserID        string `json:"user_id"`
+	ParticipantID string `json:"participant_id"`
+	TaskID        string `json:"task_id"`
+	Reason        string `json:"reason"`
+	ChangedBy     string `json:"changed_by"`
+}
+
+type adminRegradeRequest struct {
+	TaskID        string `json:"task_id"`
+	UserID        string `json:"user_id"`
+	ParticipantID string `json:"participant_id"`
+	AttemptID     int64  `json:"attempt_id"` // 指定した提出だけを再採点
+	AllUsers      bool   `json:"all_users"`  // 課題の全ユーザーをバックグラウンドで再採点（ルーブリック変更後など）
+	Reason        string `json:"reason"`
+	ChangedBy     string `json:"changed_by"`
+	Force         bool   `json:"force"` // 教員が上書きした得点も再採点の結果で置き換える
+}
+
+// 再採点1件分の結果
+type RegradeResult struct {
+	UserID        string               `json:"user_id"`
+	AttemptID     int64                `json:"attempt_id"`
+	PreviousScore int                  `json:"previous_score"` // 元の提出の得点
+	NewScore      int                  `json:"new_score"`
+	Criteria      []CriterionScore     `json:"criteria,omitempty"`
+	Applied       bool                 `json:"applied"` // task_progress に反映したか（教員の上書きがあれば反映しない）
+	Override      *GradeOverrideRecord `json:"override,omitempty"`
+	Error         string               `json:"error,omitempty"`
+}
+
+// adminGradeOverrideHandler は /api/admin/task-progress/override で教員が得点を上書きします。
+// 上書き前の AI の得点・新しい得点・理由・変更者を grade_overrides に記録する
+func adminGradeOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodPost) || !requireAdmin(w, r) {
+		return
+	}
+
+	var req adminGradeOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	taskID := strings.TrimSpace(req.TaskID)
+	reason := strings.TrimSpace(req.Reason)
+	changedBy := strings.TrimSpace(req.ChangedBy)
+	if taskID == "" || reason == "" || changedBy == "" {
+		writeJSONError(w, http.StatusBadRequest, "task_id, reason and changed_by are required")
+		return
+	}
+	if req.Score < 0 || req.Score > 100 {
+		writeJSONError(w, http.StatusBadRequest, "score must be 0..100")
+		return
+	}
+	userID, ok := resolveAdminUserID(w, req.UserID, req.ParticipantID)
+	if !ok {
+		return
+	}
+
+	isCleared := req.Score >= taskPassThreshold(taskID)
+	if req.IsCleared != nil {
+		isCleared = *req.IsCleared
+	}
+	record, _, err := adminSetTaskProgress(GradeChange{
+		UserID:    userID,
+		TaskID:    taskID,
+		Score:     req.Score,
+		IsCleared: isCleared,
+		Kind:      GradeChangeOverride,
+		Reason:    reason,
+		ChangedBy: changedBy,
+	})
+	if err != nil {
+		log.Printf("ERROR: admin grade override failed: user_id=%s task_id=%s err=%v", userID, taskID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to override score")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "success", "override": record})
+}
+
+// adminClearOverrideHandler は /api/admin/task-progress/override/clear で教員の上書きを解除します。
+// 得点は変えず、解除したことを grade_overrides に記録する
+func adminClearOverrideHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodPost) || !requireAdmin(w, r) {
+		return
+	}
+
+	var req adminClearOverrideRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	taskID := strings.TrimSpace(req.TaskID)
+	changedBy := strings.TrimSpace(req.ChangedBy)
+	if taskID == "" || changedBy == "" {
+		writeJSONError(w, http.StatusBadRequest, "task_id and changed_by are required")
+		return
+	}
+	userID, ok := resolveAdminUserID(w, req.UserID, req.ParticipantID)
+	if !ok {
+		return
+	}
+
+	record, cleared, err := adminClearTaskProgressOverride(userID, taskID, strings.TrimSpace(req.Reason), changedBy)
+	if err != nil {
+		log.Printf("ERROR: admin clear override failed: user_id=%s task_id=%s err=%v", userID, taskID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to clear override")
+		return
+	}
+	if !cleared {
+		writeJSONError(w, http.StatusNotFound, "task progress is not overridden")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "success", "override": record})
+}
+
+// adminRegradeHandler は /api/admin/task-progress/regrade で保存済みの提出を現在の課題定義で採点し直します。
+// 対象は attempt_id の提出、または user_id（all_users なら課題の全ユーザー）ごとの最高得点の提出。
+// all_users はバックグラウンドで実行し、すぐに 202 と job_id を返す（結果は /api/admin/task-progress/regrade/status/{id}）
+func adminRegradeHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodPost) || !requireAdmin(w, r) {
+		return
+	}
+
+	var req adminRegradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSONError(w, http.StatusBadRequest, "Invalid JSON")
+		return
+	}
+	taskID := strings.TrimSpace(req.TaskID)
+	changedBy := strings.TrimSpace(req.ChangedBy)
+	if taskID == "" || changedBy == "" {
+		writeJSONError(w, http.StatusBadRequest, "task_id and changed_by are required")
+		return
+	}
+	task := getTaskRegistry().Get(taskID)
+	if task == nil || len(task.GradingTests()) == 0 {
+		writeJSONError(w, http.StatusNotFound, "task not found or has no test cases")
+		return
+	}
+
+	var targets []GradeAttempt
+	switch {
+	case req.AttemptID > 0:
+		attempt, err := fetchGradeAttempt(req.AttemptID)
+		if err != nil {
+			log.Printf("ERROR: regrade attempt fetch failed: attempt_id=%d err=%v", req.AttemptID, err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch grade attempt")
+			return
+		}
+		if attempt == nil || attempt.TaskID != taskID {
+			writeJSONError(w, http.StatusNotFound, "grade attempt not found for this task")
+			return
+		}
+		targets = []GradeAttempt{*attempt}
+	default:
+		userID := ""
+		if !req.AllUsers {
+			if strings.TrimSpace(req.UserID) == "" && strings.TrimSpace(req.ParticipantID) == "" {
+				writeJSONError(w, http.StatusBadRequest, "attempt_id, user_id, participant_id or all_users is required")
+				return
+			}
+			var ok bool
+			if userID, ok = resolveAdminUserID(w, req.UserID, req.ParticipantID); !ok {
+				return
+			}
+		}
+		attempts, err := fetchGradeAttempts(userID, taskID, false, 5000)
+		if err != nil {
+			log.Printf("ERROR: regrade attempts fetch failed: user_id=%s task_id=%s err=%v", userID, taskID, err)
+			writeJSONError(w, http.StatusInternalServerError, "Failed to fetch grade attempts")
+			return
+		}
+		targets = bestAttemptPerUser(attempts)
+	}
+
+	job := startRegradeJob(task, targets, strings.TrimSpace(req.Reason), changedBy, !req.Force)
+	if req.AllUsers {
+		// 全ユーザーの再採点は時間がかかるため、job_id を返して status をポーリングしてもらう
+		w.WriteHeader(http.StatusAccepted)
+		writeJSON(w, job.Status())
+		return
+	}
+	select {
+	case <-job.done:
+	case <-r.Context().Done():
+		return
+	}
+	writeJSON(w, map[string]interface{}{"status": "success", "results": job.Status().Results})
+}
+
+// regradeAttempt は提出1件を再採点し、新しい提出記録と task_progress の変更を保存します
+func regradeAttempt(attempt GradeAttempt, task *Task, reason, changedBy string, respectOverride bool) RegradeResult {
+	result := RegradeResult{UserID: attempt.UserID, AttemptID: attempt.ID, PreviousScore: attempt.Score}
+
+	outcome, err := evaluateSubmission(context.Background(), ExecClient{User: "regrade:" + attempt.UserID}, attempt.Code, attempt.Output, task, task.GradingTests())
+	if err != nil {
+		log.Printf("ERROR: regrade failed: attempt_id=%d err=%v", attempt.ID, err)
+		result.Error = "grading failed"
+		return result
+	}
+	if outcome.AIFailed {
+		// テスト観点だけの得点で置き換えると不当に低くなるため反映しない
+		result.Error = "AI grading failed"
+		return result
+	}
+	getGradeCache().Put(gradeCacheKey(task, attempt.Code, attempt.Output), outcome)
+
+	regraded := outcome.Attempt(attempt.UserID, task.ID, attempt.Code, attempt.Output, task)
+	regraded.RegradeOf = &attempt.ID
+	regraded.Verified = len(task.GradingTests()) > 0
+	recordGradeAttempt(regraded)
+	result.NewScore = regraded.Score
+	result.Criteria = outcome.Criteria
+
+	record, applied, err := adminSetTaskProgress(GradeChange{
+		UserID:          attempt.UserID,
+		TaskID:          task.ID,
+		Score:           regraded.Score,
+		IsCleared:       regraded.IsCleared,
+		RubricScores:    outcome.Criteria,
+		Kind:            GradeChangeRegrade,
+		Reason:          reason,
+		ChangedBy:       changedBy,
+		AttemptID:       &attempt.ID,
+		RespectOverride: respectOverride,
+	})
+	if err != nil {
+		log.Printf("ERROR: regrade task_progress update failed: user_id=%s task_id=%s err=%v", attempt.UserID, task.ID, err)
+		result.Error = "failed to update task progress"
+		return result
+	}
+	result.Applied = applied
+	if applied {
+		result.Override = &record
+	}
+	return result
+}
+
+// bestAttemptPerUser はユーザーごとに最高得点の提出（同点なら新しいもの）を選びます。再採点の記録は除く
+func bestAttemptPerUser(attempts []GradeAttempt) []GradeAttempt {
+	best := map[string]int{}
+	var selected []GradeAttempt
+	for _, a := range attempts {
+		if a.RegradeOf != nil {
+			continue
+		}
+		i, ok := best[a.UserID]
+		if !ok {
+			best[a.UserID] = len(selected)
+			selected = append(selected, a)
+			continue
+		}
+		// attempts は新しい順なので、同点なら先に見たものを残す
+		if a.Score > selected[i].Score {
+			selected[i] = a
+		}
+	}
+	return selected
+}
+
+// adminGradeOverridesHandler は /api/admin/grade-overrides で教員による得点変更の記録を返します
+func adminGradeOverridesHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodGet) || !requireAdmin(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	userID := ""
+	if strings.TrimSpace(q.Get("user_id")) != "" || strings.TrimSpace(q.Get("participant_id")) != "" {
+		var ok bool
+		if userID, ok = resolveAdminUserID(w, q.Get("user_id"), q.Get("participant_id")); !ok {
+			return
+		}
+	}
+
+	builder := supabaseClient.DB.From("grade_overrides").Select("*").OrderBy("created_at", "desc").Limit(gradeAttemptsLimit(q.Get("limit"), 500, 5000))
+	if userID != "" {
+		builder.Eq("user_id", userID)
+	}
+	if taskID := strings.TrimSpace(q.Get("task_id")); taskID != "" {
+		builder.Eq("task_id", taskID)
+	}
+	var records []GradeOverrideRecord
+	if err := builder.Execute(&records); err != nil {
+		log.Printf("ERROR: admin grade overrides fetch failed: user_id=%s err=%v", userID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch grade overrides")
+		return
+	}
+	writeJSON(w, map[string]interface{}{"grade_overrides": records})
+}
+
+// resolveAdminUserID は user_id、無ければ participant_id からユーザー ID を求めます。失敗時はエラーを書き込んで false
+func resolveAdminUserID(w http.ResponseWriter, userID, participantID string) (string, bool) {
+	if userID = strings.TrimSpace(userID); userID != "" {
+		return userID, true
+	}
+	participantID = strings.TrimSpace(participantID)
+	if participantID == "" {
+		writeJSONError(w, http.StatusBadRequest, "user_id or participant_id is required")
+		return "", false
+	}
+	profile, err := fetchAdminProfileByParticipantID(participantID)
+	if err != nil {
+		log.Printf("ERROR: admin profile lookup failed: participant_id=%s err=%v", participantID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to find profile")
+		return "", false
+	}
+	return profile.ID, true
+}
+
+// taskPassThreshold は課題の合格点を返します。課題レジストリに無ければデフォルト
+func taskPassThreshold(taskID string) int {
+	if task := getTaskRegistry().Get(taskID); task != nil {
+		return task.PassThreshold
+	}
+	return defaultPassThreshold
+}
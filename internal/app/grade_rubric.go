@@ -0,0 +1,162 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ルーブリックの観点の採点方法
+const (
+	RubricKindTests  = "tests"  // テストケースの合格率で採点（Tests で対象ケースを絞れる）
+	RubricKindStatic = "static" // 静的チェックを満たした割合で採点（Checks で対象チェックを絞れる）
+	RubricKindAI     = "ai"     // AI が観点の説明に沿って採点
+)
+
+// ルーブリックの tests でランダム入力のケースをまとめて指定する名前
+const randomTestsRubricName = "random"
+
+// defaultRubric はルーブリックが無い課題で使う、正しさ（テスト）とスタイル（AI）の2観点
+func defaultRubric(stylePoints int) []RubricCriterion {
+	return []RubricCriterion{
+		{ID: "correctness", Description: "テストケースに正しい出力を返す", Points: 100 - stylePoints, Kind: RubricKindTests},
+		{ID: "style", Description: "読みやすさ、命名、適切な構造化、不要な処理がないか", Points: stylePoints, Kind: RubricKindAI},
+	}
+}
+
+// untestedRubric はテストケースの無い課題で使う、正しさとスタイルをどちらも AI が採点する2観点
+func untestedRubric(stylePoints int) []RubricCriterion {
+	return []RubricCriterion{
+		{ID: "correctness", Description: "課題文と想定出力どおりに動作する（学習者の実行結果を参考にする）", Points: 100 - stylePoints, Kind: RubricKindAI},
+		{ID: "style", Description: "読みやすさ、命名、適切な構造化、不要な処理がないか", Points: stylePoints, Kind: RubricKindAI},
+	}
+}
+
+// validateRubric は課題ファイルのルーブリックを検証します。testNames は課題のテストケース名、checkIDs は静的チェックの ID
+func validateRubric(rubric []RubricCriterion, testNames map[string]bool, checkIDs map[string]bool) error {
+	seen := map[string]bool{}
+	for i := range rubric {
+		c := &rubric[i]
+		if c.ID == "" || seen[c.ID] {
+			return fmt.Errorf("rubric[%d]: id must be unique and non-empty", i)
+		}
+		seen[c.ID] = true
+		if c.Points <= 0 {
+			return fmt.Errorf("rubric %s: points must be positive", c.ID)
+		}
+		if c.Kind == "" {
+			c.Kind = RubricKindAI
+		}
+		if len(c.Tests) > 0 && c.Kind != RubricKindTests {
+			return fmt.Errorf("rubric %s: tests can only be used with kind %q", c.ID, RubricKindTests)
+		}
+		if len(c.Checks) > 0 && c.Kind != RubricKindStatic {
+			return fmt.Errorf("rubric %s: checks can only be used with kind %q", c.ID, RubricKindStatic)
+		}
+		switch c.Kind {
+		case RubricKindTests:
+			for _, name := range c.Tests {
+				if !testNames[name] {
+					return fmt.Errorf("rubric %s: unknown test %q", c.ID, name)
+				}
+			}
+		case RubricKindStatic:
+			if len(checkIDs) == 0 {
+				return fmt.Errorf("rubric %s: kind %q requires static_checks", c.ID, RubricKindStatic)
+			}
+			for _, id := range c.Checks {
+				if !checkIDs[id] {
+					return fmt.Errorf("rubric %s: unknown static check %q", c.ID, id)
+				}
+			}
+		case RubricKindAI:
+		default:
+			return fmt.Errorf("rubric %s: unsupported kind %q", c.ID, c.Kind)
+		}
+	}
+	return nil
+}
+
+// formatRubricForPrompt は AI に採点させる観点を一覧にします
+func formatRubricForPrompt(rubric []RubricCriterion) string {
+	var b strings.Builder
+	for _, c := range rubric {
+		if c.Kind == RubricKindAI {
+			fmt.Fprintf(&b, "- %s（0〜%d点）: %s\n", c.ID, c.Points, c.Description)
+		}
+	}
+	if b.Len() == 0 {
+		return "（AI が採点する観点はありません。criteria は空配列にしてください）\n"
+	}
+	return b.String()
+}
+
+// scoreRubric は観点ごとの得点を計算し、配点の合計を 100 点に換算した総合点を返します。
+// テスト観点は cases の合否から、静的チェック観点は findings から、AI 観点は ai の採点結果から求める
+// （コンパイルエラー時はすべての観点が 0 点）
+func scoreRubric(rubric []RubricCriterion, cases []CaseResult, findings []StaticFinding, compileError bool, ai []AICriterionScore) ([]CriterionScore, int) {
+	aiScores := map[string]AICriterionScore{}
+	for _, s := range ai {
+		aiScores[s.ID] = s
+	}
+
+	scores := make([]CriterionScore, 0, len(rubric))
+	earned, total := 0, 0
+	for _, c := range rubric {
+		score := CriterionScore{ID: c.ID, Description: c.Description, Kind: c.Kind, Points: c.Points}
+		switch c.Kind {
+		case RubricKindTests:
+			if compileError {
+				score.Comment = "compile error"
+				break
+			}
+			passed, n := countPassedByName(cases, c.Tests)
+			if n > 0 {
+				score.Score = c.Points * passed / n
+			}
+			score.Comment = fmt.Sprintf("%d/%d passed", passed, n)
+		case RubricKindStatic:
+			if compileError {
+				score.Comment = "compile error"
+				break
+			}
+			satisfied, n := countSatisfiedByID(findings, c.Checks)
+			if n > 0 {
+				score.Score = c.Points * satisfied / n
+			}
+			score.Comment = fmt.Sprintf("%d/%d static checks satisfied", satisfied, n)
+		default:
+			if s, ok := aiScores[c.ID]; ok && !compileError {
+				score.Score = min(max(s.Score, 0), c.Points)
+				score.Comment = s.Comment
+			}
+		}
+		earned += score.Score
+		total += c.Points
+		scores = append(scores, score)
+	}
+	if total == 0 {
+		return scores, 0
+	}
+	return scores, min(max(earned*100/total, 0), 100)
+}
+
+// countPassedByName は names に含まれるケース（空なら全ケース）の合格数と対象数を返します。
+// names の "random" はランダム入力による模範解答との比較ケースすべてを表す
+func countPassedByName(cases []CaseResult, names []string) (int, int) {
+	filter := map[string]bool{}
+	for _, name := range names {
+		filter[name] = true
+	}
+	passed, n := 0, 0
+	for _, c := range cases {
+		matched := filter[c.Name] || filter[randomTestsRubricName] && strings.HasPrefix(c.Name, randomCasePrefix)
+		if len(filter) > 0 && !matched {
+			continue
+		}
+		n++
+		if c.Verdict == VerdictAccepted {
+			passed++
+		}
+	}
+	return passed, n
+}
@@ -0,0 +1,89 @@
+package app
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// runGradeTests は提出コードをサーバー側でビルドし、テストケースでジャッジします。
+// 実行は /api/execute と同じ実行キューを通す
+func runGradeTests(ctx context.Context, client ExecClient, code string, tests []TestCase, timeLimit time.Duration) (ResultPayload, error) {
+	payload := CodePayload{Code: code}
+	opts, err := compileOptionsFromPayload(payload)
+	if err != nil {
+		return ResultPayload{}, err
+	}
+
+	queueCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	release, err := getExecQueue().Acquire(queueCtx, client)
+	cancel()
+	if err != nil {
+		return ResultPayload{}, err
+	}
+	defer release()
+
+	dir, err := prepareSourceDir(payload)
+	if err != nil {
+		return ResultPayload{}, err
+	}
+	defer os.RemoveAll(dir)
+
+	sandbox, err := getSandbox()
+	if err != nil {
+		return ResultPayload{}, err
+	}
+	return judgeSubmission(sandbox, dir, opts, tests, timeLimit)
+}
+
+// isExecBusyError は実行キューやサンドボックスが混んでいて実行できなかったエラーか判定します
+func isExecBusyError(err error) bool {
+	return errors.Is(err, errExecQueueFull) || errors.Is(err, errExecUserBusy) || errors.Is(err, errExecIPBusy) || errors.Is(err, context.DeadlineExceeded) || errors.Is(err, errSandboxBusy)
+}
+
+// hideCaseDetails は非公開テストの入力・出力が漏れないよう、判定と計測値だけを残します
+func hideCaseDetails(cases []CaseResult) []CaseResult {
+	hidden := make([]CaseResult, len(cases))
+	for i, c := range cases {
+		hidden[i] = CaseResult{
+			Index:        c.Index,
+			Name:         c.Name,
+			Verdict:      c.Verdict,
+			TimeMs:       c.TimeMs,
+			ExitCode:     c.ExitCode,
+			Signal:       c.Signal,
+			PeakMemoryKB: c.PeakMemoryKB,
+		}
+	}
+	return hidden
+}
+
+// formatJudgeSummary は AI に渡すためのテスト結果の要約を返します（期待出力は含めない）
+func formatJudgeSummary(result ResultPayload) string {
+	if result.Verdict == VerdictCompileError {
+		summary := "コンパイルエラー\n"
+		if len(result.Diagnostics) > 0 {
+			summary += formatDiagnostics(result.Diagnostics)
+		}
+		return summary
+	}
+	var b strings.Builder
+	b.WriteString(result.Result + "\n")
+	for _, c := range result.Cases {
+		fmt.Fprintf(&b, "ケース%d: %s\n", c.Index+1, c.Verdict)
+	}
+	return b.String()
+}
+
+func countPassed(cases []CaseResult) int {
+	passed := 0
+	for _, c := range cases {
+		if c.Verdict == VerdictAccepted {
+			passed++
+		}
+	}
+	return passed
+}
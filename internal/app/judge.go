@@ -0,0 +1,175 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	VerdictAccepted          = "AC"
+	VerdictWrongAnswer       = "WA"
+	VerdictTimeLimitExceeded = "TLE"
+	VerdictRuntimeError      = "RE"
+	VerdictCompileError      = "CE"
+)
+
+const (
+	defaultCaseTimeLimit = 2 * time.Second
+	maxCaseTimeLimit     = 10 * time.Second
+	maxJudgeCases        = 50
+	maxDiffLines         = 5
+)
+
+// verdictPriority は総合判定を決めるときの優先度（大きいほど優先）
+var verdictPriority = map[string]int{
+	VerdictAccepted:          0,
+	VerdictWrongAnswer:       1,
+	VerdictRuntimeError:      2,
+	VerdictTimeLimitExceeded: 3,
+	VerdictCompileError:      4,
+}
+
+func caseTimeLimit(ms int) time.Duration {
+	if ms <= 0 {
+		return defaultCaseTimeLimit
+	}
+	limit := time.Duration(ms) * time.Millisecond
+	if limit > maxCaseTimeLimit {
+		return maxCaseTimeLimit
+	}
+	return limit
+}
+
+// judgeSubmission は dir 内のソースを一度だけコンパイルし、各テストケースで実行して判定します
+func judgeSubmission(sandbox Sandbox, dir string, opts CompileOptions, cases []TestCase, timeLimit time.Duration) (ResultPayload, error) {
+	compileCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	compiled, err := sandbox.Compile(compileCtx, dir, opts)
+	if err != nil {
+		return ResultPayload{}, err
+	}
+	diagnostics := parseCompilerDiagnostics(compiled.Stderr)
+	if compiled.TimedOut {
+		return ResultPayload{Result: "エラー:\ncompilation timed out", Phase: "compile", Verdict: VerdictCompileError}, nil
+	}
+	if compiled.ExitCode != 0 {
+		return ResultPayload{Result: "エラー:\n" + compiled.Stderr, Phase: "compile", Diagnostics: diagnostics, Verdict: VerdictCompileError}, nil
+	}
+
+	limits := sandboxLimitsFor(opts, timeLimit)
+	results := make([]CaseResult, 0, len(cases))
+	overall := VerdictAccepted
+	passed := 0
+	for i, tc := range cases {
+		res, err := judgeCase(sandbox, dir, tc, limits)
+		if err != nil {
+			return ResultPayload{}, err
+		}
+		res.Index = i
+		results = append(results, res)
+		if res.Verdict == VerdictAccepted {
+			passed++
+		}
+		if verdictPriority[res.Verdict] > verdictPriority[overall] {
+			overall = res.Verdict
+		}
+	}
+
+	return ResultPayload{
+		Result:      fmt.Sprintf("%s (%d/%d passed)", overall, passed, len(cases)),
+		Phase:       "run",
+		Diagnostics: diagnostics,
+		Verdict:     overall,
+		Cases:       results,
+	}, nil
+}
+
+func judgeCase(sandbox Sandbox, dir string, tc TestCase, limits SandboxLimits) (CaseResult, error) {
+	run, err := runSandbox(context.Background(), sandbox, dir, tc.Input, limits)
+	if err != nil {
+		return CaseResult{}, err
+	}
+
+	res := CaseResult{
+		Name:         tc.Name,
+		TimeMs:       run.Duration.Milliseconds(),
+		ExitCode:     run.ExitCode,
+		Signal:       run.Signal,
+		PeakMemoryKB: run.PeakMemoryKB,
+		Truncated:    run.Truncated,
+		Output:       run.Stdout,
+		Stderr:       run.Stderr,
+	}
+	if limits.Sanitized {
+		res.Findings = parseSanitizerFindings(run.Stderr)
+	}
+	switch {
+	case run.TimedOut:
+		res.Verdict = VerdictTimeLimitExceeded
+	case run.ExitCode != 0:
+		res.Verdict = VerdictRuntimeError
+	default:
+		if diff := diffOutputs(tc.ExpectedOutput, run.Stdout); diff != "" {
+			res.Verdict = VerdictWrongAnswer
+			res.Diff = diff
+		} else {
+			res.Verdict = VerdictAccepted
+		}
+	}
+	return res, nil
+}
+
+// normalizeOutputLines は行末の空白と末尾の空行を無視して比較できるように整形します
+func normalizeOutputLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	lines := strings.Split(s, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t")
+	}
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	return lines
+}
+
+// diffOutputs は想定出力と実際の出力の相違行を返します。一致する場合は空文字列
+func diffOutputs(expected, actual string) string {
+	want := normalizeOutputLines(expected)
+	got := normalizeOutputLines(actual)
+
+	n := len(want)
+	if len(got) > n {
+		n = len(got)
+	}
+
+	var b strings.Builder
+	shown := 0
+	for i := 0; i < n; i++ {
+		var w, g string
+		if i < len(want) {
+			w = want[i]
+		}
+		if i < len(got) {
+			g = got[i]
+		}
+		if i < len(want) && i < len(got) && w == g {
+			continue
+		}
+		if shown == maxDiffLines {
+			b.WriteString("...\n")
+			break
+		}
+		fmt.Fprintf(&b, "line %d:\n", i+1)
+		if i < len(want) {
+			fmt.Fprintf(&b, "- %s\n", w)
+		}
+		if i < len(got) {
+			fmt.Fprintf(&b, "+ %s\n", g)
+		}
+		shown++
+	}
+	return b.String()
+}
@@ -0,0 +1,77 @@
+package app
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// LocalSandbox は Docker デーモンの無い環境向けに、ホストのコンパイラと
+// rlimit（ulimit）/ Linux namespaces で実行を隔離するバックエンド。
+// chroot はしないため提出コードからホストのファイルが読める。EXEC_LOCAL_UNSAFE_HOST_FS=true の場合のみ有効
+type LocalSandbox struct {
+	UseNamespaces bool
+}
+
+func (s *LocalSandbox) Compile(ctx context.Context, dir string, opts CompileOptions) (SandboxResult, error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	args := append(opts.Args(), opts.SourceArgs(".")...)
+	cmd := exec.CommandContext(ctx, opts.Compiler, args...)
+	cmd.Dir = dir
+	stdio, out, stderr := bufferedIO()
+	res, err := runSandboxCmd(ctx, cancel, cmd, stdio, compileSandboxLimits().OutputBytes)
+	res.Stdout = out.String()
+	res.Stderr = stderr.String()
+	return res, err
+}
+
+func (s *LocalSandbox) Run(ctx context.Context, dir string, stdio SandboxIO, limits SandboxLimits) (SandboxResult, error) {
+	if limits.TimeLimit <= 0 {
+		return SandboxResult{}, fmt.Errorf("local sandbox requires a time limit")
+	}
+	ctx, cancel := context.WithTimeout(ctx, limits.TimeLimit)
+	defer cancel()
+
+	var script strings.Builder
+	cpuSeconds := int(math.Ceil(limits.TimeLimit.Seconds())) + 1
+	fmt.Fprintf(&script, "ulimit -t %d; ", cpuSeconds)
+	// ASan は巨大なシャドウメモリを予約するため ulimit -v と併用できない
+	if limits.MemoryMB > 0 && !limits.Sanitized {
+		fmt.Fprintf(&script, "ulimit -v %d; ", limits.MemoryMB*1024)
+	}
+	if limits.Pids > 0 {
+		// dash は -p、bash は -u でプロセス数を制限する
+		fmt.Fprintf(&script, "{ ulimit -p %d || ulimit -u %d; } 2>/dev/null; ", limits.Pids, limits.Pids)
+	}
+	if stdio.LineBuffered {
+		script.WriteString("command -v stdbuf >/dev/null 2>&1 && exec stdbuf -oL ./main.out; ")
+	}
+	script.WriteString("exec ./main.out")
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", script.String())
+	cmd.Dir = dir
+	// サーバーの環境変数（API キー等）を提出コードに見せない
+	cmd.Env = []string{"PATH=/usr/local/bin:/usr/bin:/bin", "HOME=/tmp", "LANG=C.UTF-8"}
+	if limits.Sanitized {
+		cmd.Env = append(cmd.Env, sanitizerEnv...)
+	}
+	// 子プロセスがパイプを開いたまま残っても Wait が返るようにする
+	cmd.WaitDelay = 500 * time.Millisecond
+	if err := configureLocalSandboxCmd(cmd, s.UseNamespaces); err != nil {
+		return SandboxResult{}, err
+	}
+	res, err := runSandboxCmd(ctx, cancel, cmd, stdio, limits.OutputBytes)
+	if cmd.ProcessState != nil {
+		res.Signal, res.PeakMemoryKB = localProcessUsage(cmd.ProcessState)
+	}
+	if cmd.Process != nil {
+		// main.out が終了した後に残った子プロセスも片付ける
+		cmd.Cancel()
+	}
+	return res, err
+}
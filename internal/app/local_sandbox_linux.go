@@ -0,0 +1,43 @@
+//go:build linux
+
+package app
+
+import (
+	"os"
+	"os/exec"
+	"syscall"
+)
+
+// nobody として新しい user/pid/net/mount/ipc/uts namespace で実行し、
+// 打ち切り時はプロセスグループごと kill する。ルートファイルシステムはホストと共有のまま
+func configureLocalSandboxCmd(cmd *exec.Cmd, useNamespaces bool) error {
+	attr := &syscall.SysProcAttr{
+		Setpgid:   true,
+		Pdeathsig: syscall.SIGKILL,
+	}
+	if useNamespaces {
+		attr.Cloneflags = syscall.CLONE_NEWUSER | syscall.CLONE_NEWPID | syscall.CLONE_NEWNET |
+			syscall.CLONE_NEWNS | syscall.CLONE_NEWIPC | syscall.CLONE_NEWUTS
+		attr.UidMappings = []syscall.SysProcIDMap{{ContainerID: 65534, HostID: os.Getuid(), Size: 1}}
+		attr.GidMappings = []syscall.SysProcIDMap{{ContainerID: 65534, HostID: os.Getgid(), Size: 1}}
+		attr.GidMappingsEnableSetgroups = false
+	}
+	cmd.SysProcAttr = attr
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return nil
+}
+
+// localProcessUsage は終了シグナル名と最大常駐メモリ(KB)を返します
+func localProcessUsage(state *os.ProcessState) (string, int64) {
+	signal := ""
+	if status, ok := state.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+		signal = status.Signal().String()
+	}
+	var peakKB int64
+	if usage, ok := state.SysUsage().(*syscall.Rusage); ok {
+		peakKB = usage.Maxrss
+	}
+	return signal, peakKB
+}
@@ -0,0 +1,20 @@
+//go:build !linux
+
+package app
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+func configureLocalSandboxCmd(cmd *exec.Cmd, useNamespaces bool) error {
+	if useNamespaces {
+		return fmt.Errorf("EXEC_LOCAL_NAMESPACES requires linux; set it to false to run with rlimits only")
+	}
+	return nil
+}
+
+func localProcessUsage(state *os.ProcessState) (string, int64) {
+	return "", 0
+}
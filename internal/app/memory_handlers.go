@@ -2,6 +2,7 @@ package app
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
@@ -110,18 +111,17 @@ func summarizeHandler(w http.ResponseWriter, r *http.Request) {
 %s
 `, string(currentMemJSON), logText)
 
-	newJSONStr, err := callOpenAI(summarySystemPrompt, userPrompt, true)
-	if err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "AI Error")
-		return
-	}
-
-	newJSONStr = cleanJSONString(newJSONStr)
 	var newProfileData UserProfile
-	if err := json.Unmarshal([]byte(newJSONStr), &newProfileData); err != nil {
-		writeJSONError(w, http.StatusInternalServerError, "AI parse error")
+	if err := generateJSON(AIPurposeSummary, summarySystemPrompt, userPrompt, summarySchema, &newProfileData); err != nil {
+		log.Printf("ERROR: summarize AI call failed: user_id=%s err=%v", req.UserID, err)
+		if errors.Is(err, errInvalidStructuredOutput) {
+			writeJSONError(w, http.StatusInternalServerError, "AI parse error")
+			return
+		}
+		writeJSONError(w, http.StatusInternalServerError, "AI Error")
 		return
 	}
+	normalizeSummary(&newProfileData)
 
 	newProfileData.ID = req.UserID
 	newProfileData.LastUpdated = time.Now().Format("2006-01-02 15:04:05")
@@ -133,7 +133,7 @@ func summarizeHandler(w http.ResponseWriter, r *http.Request) {
 		"last_updated":   time.Now().Format("2006-01-02 15:04:05"),
 	}
 
-	err = supabaseClient.DB.From("profiles").Update(updateData).Eq("id", req.UserID).Execute(nil)
+	err := supabaseClient.DB.From("profiles").Update(updateData).Eq("id", req.UserID).Execute(nil)
 	if err != nil {
 		log.Printf("ERROR: Save profile failed: user_id=%s update=%+v err=%v", req.UserID, updateData, err)
 		writeJSONError(w, http.StatusInternalServerError, "Failed to save to DB")
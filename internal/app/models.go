@@ -1,5 +1,7 @@
 package app
 
+import "encoding/json"
+
 //================================================================
 // データ構造体 (Structs)
 //================================================================
@@ -8,13 +10,89 @@ package app
 
 // /execute へのリクエストボディ
 type CodePayload struct {
-	Code  string `json:"code"`
-	Stdin string `json:"stdin"`
+	Code        string            `json:"code"`
+	Files       map[string]string `json:"files,omitempty"` // 複数ファイル構成のとき 相対パス → 内容（Code より優先）
+	Entry       string            `json:"entry,omitempty"` // main を持つソース。指定時は他の main を持つソースをリンクしない
+	Stdin       string            `json:"stdin"`
+	Compiler    string            `json:"compiler,omitempty"`      // "g++" (デフォルト) or "clang++"
+	Std         string            `json:"std,omitempty"`           // "11", "14", "17", "20"
+	Optimize    string            `json:"optimize,omitempty"`      // "O0", "O1", "O2", "O3", "Os"
+	Flags       []string          `json:"flags,omitempty"`         // 許可リストにある追加フラグのみ
+	Mode        string            `json:"mode,omitempty"`          // "sanitize" で ASan/UBSan 付きでビルド・実行
+	TestCases   []TestCase        `json:"test_cases,omitempty"`    // 指定時はジャッジモードで実行
+	TimeLimitMs int               `json:"time_limit_ms,omitempty"` // 1ケースあたりの制限時間
+	UserID      string            `json:"user_id,omitempty"`       // 実行キューでの公平性の単位。空なら接続元 IP
+	Async       bool              `json:"async,omitempty"`         // true ならすぐに job_id を返し、結果は status で取得する
+}
+
+// /api/execute が待機列に入ったときのレスポンスと /api/execute/status/{id} のレスポンス
+type ExecJobStatus struct {
+	JobID    string         `json:"job_id"`
+	Status   string         `json:"status"`             // "queued", "running", "done"
+	Position int            `json:"position,omitempty"` // queued のとき何番目に実行されるか
+	Result   *ResultPayload `json:"result,omitempty"`   // done のときの実行結果
 }
 
 // /execute からのレスポンスボディ
 type ResultPayload struct {
-	Result string `json:"result"`
+	Result       string       `json:"result"`
+	Phase        string       `json:"phase,omitempty"`       // Result を出力したフェーズ ("compile" or "run")
+	Diagnostics  []Diagnostic `json:"diagnostics,omitempty"` // コンパイラの警告・エラー
+	ExitCode     int          `json:"exit_code,omitempty"`
+	Signal       string       `json:"signal,omitempty"` // シグナルで終了した場合のシグナル名
+	WallTimeMs   int64        `json:"wall_time_ms,omitempty"`
+	PeakMemoryKB int64        `json:"peak_memory_kb,omitempty"`
+	Truncated    bool         `json:"truncated,omitempty"`          // 出力量の上限で打ち切られたか
+	Findings     []Diagnostic `json:"sanitizer_findings,omitempty"` // サニタイザ実行モードで検出した問題
+	Verdict      string       `json:"verdict,omitempty"`            // ジャッジモード時の総合判定
+	Cases        []CaseResult `json:"cases,omitempty"`              // ジャッジモード時のケース別結果
+}
+
+// コンパイラの診断情報（1件分）
+type Diagnostic struct {
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Column   int    `json:"column,omitempty"`
+	Severity string `json:"severity"` // "error", "warning", "note"
+	Message  string `json:"message"`
+}
+
+// ジャッジモードのテストケース
+type TestCase struct {
+	Name           string `json:"name,omitempty"`
+	Input          string `json:"input"`
+	ExpectedOutput string `json:"expected_output"`
+}
+
+// テストケースごとの判定結果
+type CaseResult struct {
+	Index        int          `json:"index"`
+	Name         string       `json:"name,omitempty"`
+	Verdict      string       `json:"verdict"` // "AC", "WA", "TLE", "RE", "CE"
+	TimeMs       int64        `json:"time_ms"`
+	ExitCode     int          `json:"exit_code"`
+	Signal       string       `json:"signal,omitempty"`
+	PeakMemoryKB int64        `json:"peak_memory_kb,omitempty"`
+	Truncated    bool         `json:"truncated,omitempty"`
+	Findings     []Diagnostic `json:"sanitizer_findings,omitempty"`
+	Output       string       `json:"output"`
+	Stderr       string       `json:"stderr,omitempty"`
+	Diff         string       `json:"diff,omitempty"`
+}
+
+// /api/execute/ws でクライアントから送られるメッセージ
+type ExecWSClientMessage struct {
+	Type string `json:"type"` // "start", "stdin", "eof", "kill"
+	CodePayload
+	Data string `json:"data,omitempty"` // stdin の場合: 入力テキスト
+}
+
+// /api/execute/ws でサーバーから送るメッセージ
+type ExecWSServerMessage struct {
+	Type        string         `json:"type"` // "compiled", "stdout", "stderr", "exit", "error"
+	Data        string         `json:"data,omitempty"`
+	Diagnostics []Diagnostic   `json:"diagnostics,omitempty"`
+	Result      *ResultPayload `json:"result,omitempty"` // コンパイル失敗時と exit の場合
 }
 
 // --- AIチャット用 ---
@@ -23,10 +101,12 @@ type ResultPayload struct {
 type ChatPayload struct {
 	Message     string `json:"message"`
 	Code        string `json:"code"`
-	Task        string `json:"task"`
+	Task        string `json:"task"`    // 課題レジストリに無い課題のときだけ使う
+	TaskID      string `json:"task_id"` // 指定時は課題レジストリの課題文を使う
 	LoveLevel   int    `json:"love_level"`
 	CharacterID string `json:"character_id"`
 	UserID      string `json:"user_id"`
+	SessionID   string `json:"session_id"` // 続きから話す会話のセッション（空なら新しいセッションを作る）
 	PrevParams  struct {
 		Joy      int `json:"joy"`
 		Trust    int `json:"trust"`
@@ -35,7 +115,8 @@ type ChatPayload struct {
 		Shy      int `json:"shy"`
 		Surprise int `json:"surprise"`
 	} `json:"prev_params"`
-	PrevOutput string `json:"prev_output"`
+	PrevOutput  string       `json:"prev_output"`
+	Diagnostics []Diagnostic `json:"diagnostics,omitempty"` // 直前の実行で得たコンパイラの診断情報
 }
 
 // /api/chat からのレスポンスボディ
@@ -55,7 +136,15 @@ type ChatResponse struct {
 }
 
 type ResponseFormat struct {
-	Type string `json:"type"`
+	Type       string              `json:"type"` // "json_object" or "json_schema"
+	JSONSchema *ResponseJSONSchema `json:"json_schema,omitempty"`
+}
+
+// OpenAI の Structured Outputs（response_format.type = "json_schema"）で送るスキーマ
+type ResponseJSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict bool            `json:"strict"`
 }
 
 // OpenAI API へのリクエストボディ
@@ -103,24 +192,43 @@ type GradePayload struct {
 	UserID         string `json:"user_id"`
 	TaskID         string `json:"task_id"`
 	Code           string `json:"code"`            // ユーザーのコード
-	Output         string `json:"output"`          // 実行結果の出力
-	TaskDesc       string `json:"task_desc"`       // 課題文
-	ExpectedOutput string `json:"expected_output"` // 想定出力
+	Output         string `json:"output"`          // 実行結果の出力（参考のみ。採点はサーバー側で再実行する）
+	TaskDesc       string `json:"task_desc"`       // 課題文（課題レジストリに無い課題のときだけ使う）
+	ExpectedOutput string `json:"expected_output"` // 想定出力（同上）
 }
 
 // 採点レスポンス用 (AIからのJSONをマッピング)
 type GradeResponse struct {
+	Score       int                `json:"score"`
+	Criteria    []AICriterionScore `json:"criteria"` // AI が採点するルーブリック観点ごとの得点
+	Reason      string             `json:"reason"`
+	Improvement string             `json:"improvement"`
+}
+
+// AI が返すルーブリック観点ごとの得点
+type AICriterionScore struct {
+	ID      string `json:"id"`
+	Score   int    `json:"score"`
+	Comment string `json:"comment"`
+}
+
+// 採点結果のルーブリック観点ごとの内訳（task_progress.rubric_scores にも保存する）
+type CriterionScore struct {
+	ID          string `json:"id"`
+	Description string `json:"description"`
+	Kind        string `json:"kind"`
+	Points      int    `json:"points"` // 配点
 	Score       int    `json:"score"`
-	Reason      string `json:"reason"`
-	Improvement string `json:"improvement"`
+	Comment     string `json:"comment,omitempty"`
 }
 
 // Supabase採点用の構造体
 type UserTaskProgress struct {
-	UserID    string `json:"user_id"`
-	TaskID    string `json:"task_id"`
-	HighScore int    `json:"high_score"`
-	IsCleared bool   `json:"is_cleared"`
+	UserID       string           `json:"user_id"`
+	TaskID       string           `json:"task_id"`
+	HighScore    int              `json:"high_score"`
+	IsCleared    bool             `json:"is_cleared"`
+	RubricScores []CriterionScore `json:"rubric_scores,omitempty"` // 最高得点時の観点ごとの内訳
 }
 
 // DBの profiles テーブル用構造体
@@ -181,6 +289,7 @@ type WSStreamMessage struct {
 	LoveUp     int         `json:"love_up,omitempty"`
 	Thought    string      `json:"thought,omitempty"`
 	Parameters interface{} `json:"parameters,omitempty"`
+	SessionID  string      `json:"session_id,omitempty"` // 会話を保存したセッション（次のメッセージで送り返す）
 }
 
 // OpenAI Streaming用レスポンス構造体
@@ -16,12 +16,23 @@ type OpenAIChatProvider struct {
 	Model  string
 }
 
-func (p *OpenAIChatProvider) GenerateChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage) (string, error) {
+// openAIResponseFormat はスキーマがあれば Structured Outputs、無ければ JSON モードを指定します
+func openAIResponseFormat(schema *OutputSchema) *ResponseFormat {
+	if schema == nil {
+		return &ResponseFormat{Type: "json_object"}
+	}
+	return &ResponseFormat{
+		Type:       "json_schema",
+		JSONSchema: &ResponseJSONSchema{Name: schema.Name, Schema: schema.Raw, Strict: true},
+	}
+}
+
+func (p *OpenAIChatProvider) GenerateJSON(ctx context.Context, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema) (string, error) {
 	reqMessages := append([]OpenAIMessage{{Role: "system", Content: systemPrompt}}, messages...)
 	reqBody := OpenAIRequest{
 		Model:          p.Model,
 		Messages:       reqMessages,
-		ResponseFormat: &ResponseFormat{Type: "json_object"},
+		ResponseFormat: openAIResponseFormat(schema),
 	}
 	reqBytes, err := json.Marshal(reqBody)
 	if err != nil {
@@ -56,12 +67,12 @@ func (p *OpenAIChatProvider) GenerateChat(ctx context.Context, systemPrompt stri
 	return openAIResp.Choices[0].Message.Content, nil
 }
 
-func (p *OpenAIChatProvider) StreamChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage, onDelta func(string) error) (string, error) {
+func (p *OpenAIChatProvider) StreamChat(ctx context.Context, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema, onDelta func(string) error) (string, error) {
 	reqMessages := append([]OpenAIMessage{{Role: "system", Content: systemPrompt}}, messages...)
 	reqBody := OpenAIStreamRequest{
 		Model:          p.Model,
 		Messages:       reqMessages,
-		ResponseFormat: &ResponseFormat{Type: "json_object"},
+		ResponseFormat: openAIResponseFormat(schema),
 		Stream:         true,
 	}
 	reqBytes, err := json.Marshal(reqBody)
@@ -0,0 +1,225 @@
+package app
+
+import (
+	"hash/fnv"
+	"log"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 類似度の計算に使うトークンの k-gram の長さと、winnowing の窓の大きさ
+const (
+	similarityKGram  = 5
+	similarityWindow = 4
+)
+
+// 識別子のうち、正規化せずそのまま残すもの（キーワードと標準ライブラリの名前）。
+// それ以外の変数名・関数名はすべて同じトークンにして、名前の付け替えでは類似度が下がらないようにする
+var cppKeptIdentifiers = map[string]bool{
+	"auto": true, "bool": true, "break": true, "case": true, "char": true, "class": true, "const": true,
+	"continue": true, "default": true, "delete": true, "do": true, "double": true, "else": true, "enum": true,
+	"false": true, "float": true, "for": true, "if": true, "int": true, "long": true, "new": true,
+	"nullptr": true, "private": true, "public": true, "return": true, "short": true, "signed": true,
+	"sizeof": true, "static": true, "struct": true, "switch": true, "template": true, "this": true,
+	"true": true, "typename": true, "unsigned": true, "using": true, "void": true, "while": true,
+	"namespace": true, "std": true, "cin": true, "cout": true, "cerr": true, "endl": true, "string": true,
+	"vector": true, "map": true, "set": true, "pair": true, "size": true, "push_back": true, "begin": true,
+	"end": true, "sort": true, "max": true, "min": true, "swap": true, "getline": true, "printf": true,
+	"scanf": true, "main": true,
+}
+
+// SimilarityPair は類似度が高い提出の組
+type SimilarityPair struct {
+	UserA        string  `json:"user_a"`
+	ParticipantA string  `json:"participant_a"`
+	RoleA        string  `json:"role_a"`
+	AttemptA     int64   `json:"attempt_a"`
+	UserB        string  `json:"user_b"`
+	ParticipantB string  `json:"participant_b"`
+	RoleB        string  `json:"role_b"`
+	AttemptB     int64   `json:"attempt_b"`
+	Similarity   float64 `json:"similarity"` // 指紋の Jaccard 係数（0〜1）
+	SharedPrints int     `json:"shared_fingerprints"`
+	SameGroup    bool    `json:"same_group"` // 同じ群（profiles.role）どうしか
+}
+
+// cppTokens は C++ のコードを類似度判定用のトークン列にします。
+// 識別子・数値・文字列リテラルは種類ごとに1つのトークンにまとめる
+func cppTokens(code string) []string {
+	lexed := lexCpp(code)
+	tokens := make([]string, len(lexed))
+	for i, tok := range lexed {
+		switch tok.Kind {
+		case cppTokenIdent:
+			if cppKeptIdentifiers[tok.Text] {
+				tokens[i] = tok.Text
+			} else {
+				tokens[i] = "ID"
+			}
+		case cppTokenNumber:
+			tokens[i] = "NUM"
+		case cppTokenString:
+			tokens[i] = "STR"
+		case cppTokenChar:
+			tokens[i] = "CHR"
+		default:
+			tokens[i] = tok.Text
+		}
+	}
+	return tokens
+}
+
+// winnowFingerprints はトークンの k-gram のハッシュから winnowing で指紋を選びます
+func winnowFingerprints(tokens []string, k, window int) map[uint64]bool {
+	prints := map[uint64]bool{}
+	if len(tokens) == 0 {
+		return prints
+	}
+	k = min(k, len(tokens))
+	hashes := make([]uint64, 0, len(tokens)-k+1)
+	for i := 0; i+k <= len(tokens); i++ {
+		h := fnv.New64a()
+		for _, tok := range tokens[i : i+k] {
+			h.Write([]byte(tok))
+			h.Write([]byte{0})
+		}
+		hashes = append(hashes, h.Sum64())
+	}
+	window = min(window, len(hashes))
+	for start := 0; start+window <= len(hashes); start++ {
+		// 窓の中の最小値（同じ値なら右端）を選ぶ
+		minIdx := start
+		for j := start; j < start+window; j++ {
+			if hashes[j] <= hashes[minIdx] {
+				minIdx = j
+			}
+		}
+		prints[hashes[minIdx]] = true
+	}
+	return prints
+}
+
+// submissionFingerprints は提出ごとの指紋を求め、半数を超える提出に共通する指紋（課題の定型部分）を取り除きます
+func submissionFingerprints(codes []string) []map[uint64]bool {
+	prints := make([]map[uint64]bool, len(codes))
+	counts := map[uint64]int{}
+	for i, code := range codes {
+		prints[i] = winnowFingerprints(cppTokens(code), similarityKGram, similarityWindow)
+		for h := range prints[i] {
+			counts[h]++
+		}
+	}
+	if len(codes) >= 4 {
+		for _, p := range prints {
+			for h := range p {
+				if counts[h]*2 > len(codes) {
+					delete(p, h)
+				}
+			}
+		}
+	}
+	return prints
+}
+
+// jaccardSimilarity は指紋集合の Jaccard 係数と共通する指紋の数を返します
+func jaccardSimilarity(a, b map[uint64]bool) (float64, int) {
+	if len(a) > len(b) {
+		a, b = b, a
+	}
+	shared := 0
+	for h := range a {
+		if b[h] {
+			shared++
+		}
+	}
+	union := len(a) + len(b) - shared
+	if union == 0 {
+		return 0, 0
+	}
+	return float64(shared) / float64(union), shared
+}
+
+// adminSimilarityHandler は /api/admin/similarity?task_id=&threshold= で、各ユーザーの最高得点の提出どうしを比べ、
+// 類似度が threshold（デフォルト 0.7）以上の組を類似度の高い順に返します
+func adminSimilarityHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodGet) || !requireAdmin(w, r) {
+		return
+	}
+
+	q := r.URL.Query()
+	taskID := strings.TrimSpace(q.Get("task_id"))
+	if taskID == "" {
+		writeJSONError(w, http.StatusBadRequest, "task_id is required")
+		return
+	}
+	threshold := 0.7
+	if raw := q.Get("threshold"); raw != "" {
+		parsed, err := strconv.ParseFloat(raw, 64)
+		if err != nil || parsed < 0 || parsed > 1 {
+			writeJSONError(w, http.StatusBadRequest, "threshold must be 0..1")
+			return
+		}
+		threshold = parsed
+	}
+
+	attempts, err := fetchGradeAttempts("", taskID, false, 5000)
+	if err != nil {
+		log.Printf("ERROR: similarity attempts fetch failed: task_id=%s err=%v", taskID, err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch grade attempts")
+		return
+	}
+	submissions := bestAttemptPerUser(attempts)
+
+	var profiles []UserProfile
+	if err := supabaseClient.DB.From("profiles").Select("id,participant_id,role").Execute(&profiles); err != nil {
+		log.Printf("ERROR: similarity profiles fetch failed: %v", err)
+		writeJSONError(w, http.StatusInternalServerError, "Failed to fetch profiles")
+		return
+	}
+	profileByID := map[string]UserProfile{}
+	for _, p := range profiles {
+		profileByID[p.ID] = p
+	}
+
+	codes := make([]string, len(submissions))
+	for i, s := range submissions {
+		codes[i] = s.Code
+	}
+	prints := submissionFingerprints(codes)
+
+	pairs := []SimilarityPair{}
+	for i := range submissions {
+		for j := i + 1; j < len(submissions); j++ {
+			similarity, shared := jaccardSimilarity(prints[i], prints[j])
+			if shared == 0 || similarity < threshold {
+				continue
+			}
+			a, b := profileByID[submissions[i].UserID], profileByID[submissions[j].UserID]
+			pairs = append(pairs, SimilarityPair{
+				UserA:        submissions[i].UserID,
+				ParticipantA: a.ParticipantID,
+				RoleA:        a.Role,
+				AttemptA:     submissions[i].ID,
+				UserB:        submissions[j].UserID,
+				ParticipantB: b.ParticipantID,
+				RoleB:        b.Role,
+				AttemptB:     submissions[j].ID,
+				Similarity:   math.Round(similarity*1000) / 1000,
+				SharedPrints: shared,
+				SameGroup:    a.Role == b.Role,
+			})
+		}
+	}
+	sort.Slice(pairs, func(i, j int) bool { return pairs[i].Similarity > pairs[j].Similarity })
+
+	writeJSON(w, map[string]interface{}{
+		"task_id":     taskID,
+		"submissions": len(submissions),
+		"threshold":   threshold,
+		"pairs":       pairs,
+	})
+}
@@ -0,0 +1,104 @@
+package app
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	maxProjectFiles      = 32
+	maxProjectTotalBytes = 512 * 1024
+)
+
+var projectSourceExts = map[string]bool{".cpp": true, ".cc": true, ".cxx": true}
+
+var projectFileExts = map[string]bool{
+	".cpp": true, ".cc": true, ".cxx": true,
+	".h": true, ".hpp": true, ".hxx": true,
+	".txt": true,
+}
+
+// シェルのコマンドラインにそのまま埋め込めるよう、ファイル名に使える文字を制限する。
+// コンパイラのオプションと解釈されないよう、各要素の先頭に - は使えない
+var projectPathRegex = regexp.MustCompile(`^[A-Za-z0-9_][A-Za-z0-9_\-]*(/[A-Za-z0-9_][A-Za-z0-9_\-]*)*\.[A-Za-z]+$`)
+
+var mainFunctionRegex = regexp.MustCompile(`\bint\s+main\s*\(`)
+
+// projectFilesFromPayload はリクエストのファイル群を検証し、相対パス → 内容のマップを返します。
+// Files が空の場合は従来どおり Code を main.cpp として扱う
+func projectFilesFromPayload(p CodePayload) (map[string]string, error) {
+	if len(p.Files) == 0 {
+		return map[string]string{"main.cpp": p.Code}, nil
+	}
+	if len(p.Files) > maxProjectFiles {
+		return nil, fmt.Errorf("too many files (max %d)", maxProjectFiles)
+	}
+
+	files := make(map[string]string, len(p.Files))
+	total := 0
+	for name, content := range p.Files {
+		clean, err := validateProjectPath(name)
+		if err != nil {
+			return nil, err
+		}
+		if _, dup := files[clean]; dup {
+			return nil, fmt.Errorf("duplicate file path: %s", name)
+		}
+		total += len(content)
+		if total > maxProjectTotalBytes {
+			return nil, fmt.Errorf("files are too large (max %d KB in total)", maxProjectTotalBytes/1024)
+		}
+		files[clean] = content
+	}
+	return files, nil
+}
+
+// validateProjectPath は一時ディレクトリの外や隠しファイルを指すパスを拒否します
+func validateProjectPath(name string) (string, error) {
+	clean := path.Clean(strings.ReplaceAll(strings.TrimSpace(name), "\\", "/"))
+	if !projectPathRegex.MatchString(clean) {
+		return "", fmt.Errorf("invalid file path: %q", name)
+	}
+	if !projectFileExts[path.Ext(clean)] {
+		return "", fmt.Errorf("unsupported file type: %q", name)
+	}
+	return clean, nil
+}
+
+// projectSources はコンパイル対象のソースファイルを返します。
+// entry を指定した場合は entry と、main 関数を持たない他のソースだけをリンクする
+func projectSources(files map[string]string, entry string) ([]string, error) {
+	var sources []string
+	for name := range files {
+		if projectSourceExts[path.Ext(name)] {
+			sources = append(sources, name)
+		}
+	}
+	sort.Strings(sources)
+	if len(sources) == 0 {
+		return nil, fmt.Errorf("no source files (.cpp, .cc, .cxx)")
+	}
+
+	entry = strings.TrimSpace(entry)
+	if entry == "" {
+		return sources, nil
+	}
+	entry, err := validateProjectPath(entry)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := files[entry]; !ok || !projectSourceExts[path.Ext(entry)] {
+		return nil, fmt.Errorf("entry must be one of the source files: %s", entry)
+	}
+
+	selected := []string{entry}
+	for _, name := range sources {
+		if name != entry && !mainFunctionRegex.MatchString(files[name]) {
+			selected = append(selected, name)
+		}
+	}
+	return selected, nil
+}
@@ -0,0 +1,126 @@
+package app
+
+import (
+	"net/http"
+	"sync"
+	"time"
+)
+
+const regradeJobTTL = time.Hour
+
+var (
+	regradeJobsMu sync.Mutex
+	regradeJobs   = map[string]*RegradeJob{}
+)
+
+// RegradeJob は課題の全ユーザーを再採点するバックグラウンドジョブ。
+// HTTP リクエストの時間制限や切断に影響されないよう、リクエストとは別に実行する
+type RegradeJob struct {
+	ID     string
+	TaskID string
+
+	mu         sync.Mutex
+	status     string
+	results    []RegradeResult
+	completed  int
+	finishedAt time.Time
+	done       chan struct{}
+}
+
+// RegradeJobStatus は /api/admin/task-progress/regrade/status/{id} のレスポンス
+type RegradeJobStatus struct {
+	JobID     string          `json:"job_id"`
+	TaskID    string          `json:"task_id"`
+	Status    string          `json:"status"` // "running" or "done"
+	Total     int             `json:"total"`
+	Completed int             `json:"completed"`
+	Results   []RegradeResult `json:"results,omitempty"` // done のときの再採点結果
+}
+
+// startRegradeJob は targets の再採点をバックグラウンドで始めます
+func startRegradeJob(task *Task, targets []GradeAttempt, reason, changedBy string, respectOverride bool) *RegradeJob {
+	job := &RegradeJob{
+		ID:      newExecJobID(),
+		TaskID:  task.ID,
+		status:  ExecJobRunning,
+		results: make([]RegradeResult, len(targets)),
+		done:    make(chan struct{}),
+	}
+
+	regradeJobsMu.Lock()
+	purgeRegradeJobs()
+	regradeJobs[job.ID] = job
+	regradeJobsMu.Unlock()
+
+	go func() {
+		sem := make(chan struct{}, regradeConcurrency)
+		var wg sync.WaitGroup
+		for i, attempt := range targets {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func() {
+				defer wg.Done()
+				defer func() { <-sem }()
+				result := regradeAttempt(attempt, task, reason, changedBy, respectOverride)
+				job.mu.Lock()
+				job.results[i] = result
+				job.completed++
+				job.mu.Unlock()
+			}()
+		}
+		wg.Wait()
+
+		job.mu.Lock()
+		job.status = ExecJobDone
+		job.finishedAt = time.Now()
+		job.mu.Unlock()
+		close(job.done)
+	}()
+	return job
+}
+
+// getRegradeJob は ID に対応するジョブを返します。完了後 regradeJobTTL を過ぎたものは見つからない
+func getRegradeJob(id string) *RegradeJob {
+	regradeJobsMu.Lock()
+	defer regradeJobsMu.Unlock()
+	purgeRegradeJobs()
+	return regradeJobs[id]
+}
+
+// purgeRegradeJobs は保持期間を過ぎた完了済みジョブを削除します。regradeJobsMu を保持して呼ぶこと
+func purgeRegradeJobs() {
+	now := time.Now()
+	for id, job := range regradeJobs {
+		job.mu.Lock()
+		expired := job.status == ExecJobDone && now.Sub(job.finishedAt) > regradeJobTTL
+		job.mu.Unlock()
+		if expired {
+			delete(regradeJobs, id)
+		}
+	}
+}
+
+// Status は再採点の進み具合を返します。結果は完了後にまとめて返す
+func (j *RegradeJob) Status() RegradeJobStatus {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	status := RegradeJobStatus{JobID: j.ID, TaskID: j.TaskID, Status: j.status, Total: len(j.results), Completed: j.completed}
+	if j.status == ExecJobDone {
+		status.Results = j.results
+	}
+	return status
+}
+
+// adminRegradeStatusHandler は /api/admin/task-progress/regrade/status/{id} で再採点ジョブの状態を返します
+func adminRegradeStatusHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	if !requireMethod(w, r, http.MethodGet) || !requireAdmin(w, r) {
+		return
+	}
+	job := getRegradeJob(r.PathValue("id"))
+	if job == nil {
+		writeJSONError(w, http.StatusNotFound, "regrade job not found")
+		return
+	}
+	writeJSON(w, job.Status())
+}
@@ -0,0 +1,260 @@
+package app
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// SandboxLimits は1回の実行に課す制限。0 は無制限
+type SandboxLimits struct {
+	TimeLimit   time.Duration
+	MemoryMB    int
+	CPUs        float64 // LocalSandbox では未対応（CPU時間は TimeLimit から ulimit -t で制限）
+	Pids        int
+	OutputBytes int  // stdout / stderr それぞれの上限。超えた時点でプロセスを停止する
+	Sanitized   bool // サニタイザ付きバイナリ。実行時オプションを渡し、LocalSandbox では仮想メモリ制限を外す
+}
+
+// SandboxResult はコンパイルまたは実行の結果。ExitCode が非0でもエラーとはしない
+type SandboxResult struct {
+	Stdout       string
+	Stderr       string
+	ExitCode     int
+	Signal       string
+	TimedOut     bool
+	Truncated    bool
+	Duration     time.Duration
+	PeakMemoryKB int64
+}
+
+// SandboxIO は実行中のプログラムの標準入出力の接続先
+type SandboxIO struct {
+	Stdin        io.Reader
+	Stdout       io.Writer
+	Stderr       io.Writer
+	LineBuffered bool // 対話実行用に stdout を行バッファにする
+}
+
+// Sandbox は dir 内の opts.Sources を opts に従って main.out にコンパイルし、隔離環境で実行するバックエンド。
+// Run の出力は stdio に書き込まれ、SandboxResult の Stdout / Stderr は空になる。
+// error はバックエンド自体の障害（docker が無い等）のみを表す
+type Sandbox interface {
+	Compile(ctx context.Context, dir string, opts CompileOptions) (SandboxResult, error)
+	Run(ctx context.Context, dir string, stdio SandboxIO, limits SandboxLimits) (SandboxResult, error)
+}
+
+const (
+	defaultSandboxMemoryMB = 256
+	defaultSandboxCPUs     = 1.0
+	defaultSandboxPids     = 64
+	defaultSandboxOutputKB = 64
+
+	// コンパイラはテンプレートの展開などで実行時より多くのメモリを使い、診断メッセージも長くなる
+	defaultCompileMemoryMB = 1024
+	defaultCompileOutputKB = 256
+)
+
+func getSandbox() (Sandbox, error) {
+	backend := strings.ToLower(strings.TrimSpace(os.Getenv("EXEC_SANDBOX")))
+	if backend == "" {
+		backend = "docker"
+	}
+
+	switch backend {
+	case "docker":
+		image := strings.TrimSpace(os.Getenv("EXEC_DOCKER_IMAGE"))
+		if image == "" {
+			image = "gcc:latest"
+		}
+		return &DockerSandbox{Image: image}, nil
+	case "docker-pool":
+		image := strings.TrimSpace(os.Getenv("EXEC_DOCKER_IMAGE"))
+		if image == "" {
+			image = "gcc:latest"
+		}
+		size := positiveIntEnv("EXEC_POOL_SIZE", 4)
+		queue := positiveIntEnv("EXEC_POOL_QUEUE", 32)
+		return getDockerPool(image, size, queue, defaultSandboxLimits(0)), nil
+	case "local":
+		// LocalSandbox はファイルシステムを隔離しないため、提出コードからホストのファイル（.env 等）が読める。
+		// 信頼できるコードだけを実行する環境で明示的に許可した場合のみ使う
+		allowHostFS := false
+		if raw := strings.TrimSpace(os.Getenv("EXEC_LOCAL_UNSAFE_HOST_FS")); raw != "" {
+			parsed, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("EXEC_LOCAL_UNSAFE_HOST_FS must be true or false")
+			}
+			allowHostFS = parsed
+		}
+		if !allowHostFS {
+			return nil, fmt.Errorf("EXEC_SANDBOX=local exposes the host filesystem to submitted code; set EXEC_LOCAL_UNSAFE_HOST_FS=true to use it anyway")
+		}
+		useNamespaces := true
+		if raw := strings.TrimSpace(os.Getenv("EXEC_LOCAL_NAMESPACES")); raw != "" {
+			parsed, err := strconv.ParseBool(raw)
+			if err != nil {
+				return nil, fmt.Errorf("EXEC_LOCAL_NAMESPACES must be true or false")
+			}
+			useNamespaces = parsed
+		}
+		return &LocalSandbox{UseNamespaces: useNamespaces}, nil
+	default:
+		return nil, fmt.Errorf("unsupported EXEC_SANDBOX: %s", backend)
+	}
+}
+
+func defaultSandboxLimits(timeLimit time.Duration) SandboxLimits {
+	cpus := defaultSandboxCPUs
+	if raw := strings.TrimSpace(os.Getenv("EXEC_CPUS")); raw != "" {
+		if parsed, err := strconv.ParseFloat(raw, 64); err == nil && parsed > 0 {
+			cpus = parsed
+		} else {
+			log.Printf("WARNING: EXEC_CPUS must be a positive number. Using default %.1f.", defaultSandboxCPUs)
+		}
+	}
+	return SandboxLimits{
+		TimeLimit:   timeLimit,
+		MemoryMB:    positiveIntEnv("EXEC_MEMORY_MB", defaultSandboxMemoryMB),
+		CPUs:        cpus,
+		Pids:        positiveIntEnv("EXEC_PIDS", defaultSandboxPids),
+		OutputBytes: positiveIntEnv("EXEC_OUTPUT_KB", defaultSandboxOutputKB) * 1024,
+	}
+}
+
+// compileSandboxLimits はコンパイル用コンテナの制限を返します。制限時間は呼び出し側の ctx で決める
+func compileSandboxLimits() SandboxLimits {
+	limits := defaultSandboxLimits(0)
+	limits.MemoryMB = positiveIntEnv("EXEC_COMPILE_MEMORY_MB", defaultCompileMemoryMB)
+	limits.OutputBytes = positiveIntEnv("EXEC_COMPILE_OUTPUT_KB", defaultCompileOutputKB) * 1024
+	return limits
+}
+
+// sandboxLimitsFor はコンパイル設定に合わせた実行時の制限を返します
+func sandboxLimitsFor(opts CompileOptions, timeLimit time.Duration) SandboxLimits {
+	limits := defaultSandboxLimits(timeLimit)
+	limits.Sanitized = opts.Sanitize
+	return limits
+}
+
+func positiveIntEnv(name string, defaultValue int) int {
+	if raw := strings.TrimSpace(os.Getenv(name)); raw != "" {
+		if parsed, err := strconv.Atoi(raw); err == nil && parsed > 0 {
+			return parsed
+		}
+		log.Printf("WARNING: %s must be a positive integer. Using default %d.", name, defaultValue)
+	}
+	return defaultValue
+}
+
+// runSandbox は stdin を与えて実行し、出力をまとめて SandboxResult に入れて返します
+func runSandbox(ctx context.Context, sandbox Sandbox, dir string, stdin string, limits SandboxLimits) (SandboxResult, error) {
+	stdio, out, stderr := bufferedIO()
+	if stdin != "" {
+		stdio.Stdin = strings.NewReader(stdin)
+	}
+	res, err := sandbox.Run(ctx, dir, stdio, limits)
+	res.Stdout = out.String()
+	res.Stderr = stderr.String()
+	return res, err
+}
+
+// runSandboxCmd は cmd を stdio に接続して実行します。出力が outputLimit を超えたら cancel でプロセスを止める。
+// ctx は cancel と対になるもので、cmd もこの ctx で作られている必要がある
+func runSandboxCmd(ctx context.Context, cancel context.CancelFunc, cmd *exec.Cmd, stdio SandboxIO, outputLimit int) (SandboxResult, error) {
+	cmd.Stdin = stdio.Stdin
+	out := &limitedWriter{w: stdio.Stdout, limit: outputLimit, onExceed: cancel}
+	stderr := &limitedWriter{w: stdio.Stderr, limit: outputLimit, onExceed: cancel}
+	cmd.Stdout = out
+	cmd.Stderr = stderr
+	if cmd.WaitDelay == 0 {
+		// stdin が対話入力のパイプだとプロセス終了後も読み込みが返らないため、待ち時間に上限を設ける
+		cmd.WaitDelay = time.Second
+	}
+
+	start := time.Now()
+	err := cmd.Run()
+	if errors.Is(err, exec.ErrWaitDelay) {
+		err = nil
+	}
+	res := SandboxResult{
+		Truncated: out.truncated || stderr.truncated,
+		Duration:  time.Since(start),
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		res.TimedOut = true
+		res.ExitCode = -1
+		return res, nil
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		res.ExitCode = exitErr.ExitCode()
+		return res, nil
+	}
+	if err != nil {
+		return res, fmt.Errorf("%s failed: %w", filepath.Base(cmd.Path), err)
+	}
+	return res, nil
+}
+
+// bufferedIO はコンパイルなど出力をまとめて受け取りたい場合の SandboxIO を返します
+func bufferedIO() (SandboxIO, *bytes.Buffer, *bytes.Buffer) {
+	var out bytes.Buffer
+	var stderr bytes.Buffer
+	return SandboxIO{Stdout: &out, Stderr: &stderr}, &out, &stderr
+}
+
+// limitedWriter は limit を超えた出力を捨て、最初に超えた時点で onExceed を呼びます
+type limitedWriter struct {
+	w         io.Writer
+	limit     int
+	written   int
+	truncated bool
+	onExceed  func()
+}
+
+func (lw *limitedWriter) Write(p []byte) (int, error) {
+	if lw.w == nil {
+		lw.w = io.Discard
+	}
+	if lw.limit <= 0 {
+		return lw.w.Write(p)
+	}
+	remaining := lw.limit - lw.written
+	if len(p) <= remaining {
+		lw.written += len(p)
+		return lw.w.Write(p)
+	}
+	if remaining > 0 {
+		lw.written += remaining
+		if _, err := lw.w.Write(p[:remaining]); err != nil {
+			return 0, err
+		}
+	}
+	if !lw.truncated {
+		lw.truncated = true
+		if lw.onExceed != nil {
+			lw.onExceed()
+		}
+	}
+	return len(p), nil
+}
+
+// shellExitSignal は sh / timeout が返す 128+N の終了コードをシグナル名に変換します
+func shellExitSignal(exitCode int) string {
+	if exitCode <= 128 || exitCode >= 128+65 {
+		return ""
+	}
+	return syscall.Signal(exitCode - 128).String()
+}
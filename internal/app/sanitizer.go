@@ -0,0 +1,72 @@
+package app
+
+import (
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const ExecModeSanitize = "sanitize"
+
+// サニタイザ実行モードでのコンパイルフラグ。行番号を取れるよう -g を付け、最適化は控えめにする
+var sanitizerCompileFlags = []string{"-fsanitize=address,undefined", "-fno-omit-frame-pointer", "-g", "-O1"}
+
+// サニタイザの実行時オプション。UBSan は最初の1件で止めず、すべて報告させる
+var sanitizerEnv = []string{
+	"ASAN_OPTIONS=halt_on_error=1:detect_leaks=1:color=never",
+	"UBSAN_OPTIONS=print_stacktrace=1:halt_on_error=0:color=never",
+}
+
+// UBSan: "main.cpp:5:12: runtime error: signed integer overflow: ..."
+var ubsanFindingRegex = regexp.MustCompile(`^(.+?):(\d+):(\d+): runtime error: (.*)$`)
+
+// ASan / LSan: "==123==ERROR: AddressSanitizer: heap-buffer-overflow on address ..."
+var asanErrorRegex = regexp.MustCompile(`^==\d+==ERROR: (AddressSanitizer|LeakSanitizer): (.*)$`)
+
+// スタックフレーム: "    #0 0x55d1c2 in main /usr/src/app/main.cpp:7:13"
+var asanFrameRegex = regexp.MustCompile(`^\s*#\d+ 0x[0-9a-f]+ in \S+ (.+?):(\d+)(?::(\d+))?$`)
+
+// parseSanitizerFindings は ASan / UBSan が stderr に出力したレポートを診断情報に変換します。
+// ASan の発生箇所は、スタックの中で最初に提出コード内を指すフレームとする
+func parseSanitizerFindings(stderr string) []Diagnostic {
+	var findings []Diagnostic
+	var current *Diagnostic
+	for _, line := range strings.Split(strings.ReplaceAll(stderr, "\r\n", "\n"), "\n") {
+		if m := ubsanFindingRegex.FindStringSubmatch(line); m != nil {
+			lineNum, _ := strconv.Atoi(m[2])
+			column, _ := strconv.Atoi(m[3])
+			findings = append(findings, Diagnostic{
+				File:     relativeSourcePath(m[1]),
+				Line:     lineNum,
+				Column:   column,
+				Severity: "error",
+				Message:  "UndefinedBehaviorSanitizer: " + m[4],
+			})
+			current = nil
+			continue
+		}
+		if m := asanErrorRegex.FindStringSubmatch(line); m != nil {
+			message, _, _ := strings.Cut(m[2], " at pc ")
+			findings = append(findings, Diagnostic{Severity: "error", Message: m[1] + ": " + message})
+			current = &findings[len(findings)-1]
+			continue
+		}
+		if current == nil || current.File != "" {
+			continue
+		}
+		if m := asanFrameRegex.FindStringSubmatch(line); m != nil && isSubmissionSource(m[1]) {
+			current.File = relativeSourcePath(m[1])
+			current.Line, _ = strconv.Atoi(m[2])
+			current.Column, _ = strconv.Atoi(m[3])
+		}
+	}
+	return findings
+}
+
+// isSubmissionSource は標準ライブラリやサニタイザのランタイムではなく、提出されたソースかどうかを判定します
+func isSubmissionSource(path string) bool {
+	if strings.HasPrefix(path, "/usr/src/app/") || strings.Contains(path, "/cpp-execution-") {
+		return true
+	}
+	return !strings.HasPrefix(path, "/") && !strings.Contains(path, "sanitizer_common")
+}
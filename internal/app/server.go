@@ -21,6 +21,8 @@ const MEMORY_FILE = "user_memory.json"
 
 var summarySystemPrompt string
 
+var chatHistorySummarySystemPrompt string
+
 var supabaseClient *supabase.Client
 
 func Run() {
@@ -45,11 +47,26 @@ func Run() {
 
 	loadGradeSystemPrompt()
 	loadSummarySystemPrompt()
+	loadChatHistorySummarySystemPrompt()
+	getTaskRegistry()
+
+	// docker-pool の場合はここでワーカーコンテナを起動しておく
+	if _, err := getSandbox(); err != nil {
+		log.Printf("WARNING: execution sandbox is not configured: %v", err)
+	}
 
 	http.Handle("/api/execute", corsMiddleware(http.HandlerFunc(executeHandler)))
+	http.Handle("/api/execute/status/{id}", corsMiddleware(http.HandlerFunc(executeStatusHandler)))
+	http.HandleFunc("/api/execute/ws", executeWSHandler)
 	http.HandleFunc("/api/chat/ws", chatWSHandler)
 	http.Handle("/api/chat", corsMiddleware(http.HandlerFunc(chatHandler)))
+	http.Handle("/api/chat/sessions", corsMiddleware(http.HandlerFunc(chatSessionsHandler)))
+	http.Handle("/api/chat/sessions/{id}", corsMiddleware(http.HandlerFunc(chatSessionDetailHandler)))
+	http.Handle("/api/chat/sessions/{id}/delete", corsMiddleware(http.HandlerFunc(chatSessionDeleteHandler)))
 	http.Handle("/api/grade", corsMiddleware(http.HandlerFunc(gradeHandler)))
+	http.Handle("/api/grade/history", corsMiddleware(http.HandlerFunc(gradeHistoryHandler)))
+	http.Handle("/api/tasks", corsMiddleware(http.HandlerFunc(tasksHandler)))
+	http.Handle("/api/tasks/{id}", corsMiddleware(http.HandlerFunc(taskDetailHandler)))
 	http.Handle("/api/memory", corsMiddleware(http.HandlerFunc(getMemoryHandler)))
 	http.Handle("/api/summarize", corsMiddleware(http.HandlerFunc(summarizeHandler)))
 	http.Handle("/api/experiment-log", corsMiddleware(http.HandlerFunc(experimentLogHandler)))
@@ -57,9 +74,16 @@ func Run() {
 	http.Handle("/api/admin/profiles", corsMiddleware(http.HandlerFunc(adminProfilesHandler)))
 	http.Handle("/api/admin/events", corsMiddleware(http.HandlerFunc(adminEventsHandler)))
 	http.Handle("/api/admin/task-progress", corsMiddleware(http.HandlerFunc(adminTaskProgressHandler)))
+	http.Handle("/api/admin/grade-attempts", corsMiddleware(http.HandlerFunc(adminGradeAttemptsHandler)))
 	http.Handle("/api/admin/experiment-data", corsMiddleware(http.HandlerFunc(adminExperimentDataHandler)))
 	http.Handle("/api/admin/profile/update", corsMiddleware(http.HandlerFunc(adminProfileUpdateHandler)))
 	http.Handle("/api/admin/task-progress/update", corsMiddleware(http.HandlerFunc(adminTaskProgressUpdateHandler)))
+	http.Handle("/api/admin/task-progress/override", corsMiddleware(http.HandlerFunc(adminGradeOverrideHandler)))
+	http.Handle("/api/admin/task-progress/override/clear", corsMiddleware(http.HandlerFunc(adminClearOverrideHandler)))
+	http.Handle("/api/admin/task-progress/regrade", corsMiddleware(http.HandlerFunc(adminRegradeHandler)))
+	http.Handle("/api/admin/task-progress/regrade/status/{id}", corsMiddleware(http.HandlerFunc(adminRegradeStatusHandler)))
+	http.Handle("/api/admin/grade-overrides", corsMiddleware(http.HandlerFunc(adminGradeOverridesHandler)))
+	http.Handle("/api/admin/similarity", corsMiddleware(http.HandlerFunc(adminSimilarityHandler)))
 	http.Handle("/api/admin/user/delete", corsMiddleware(http.HandlerFunc(adminDeleteUserHandler)))
 	http.Handle("/api/admin/reset/task-progress", corsMiddleware(http.HandlerFunc(adminResetTaskProgressHandler)))
 	http.Handle("/api/admin/reset/experiment-events", corsMiddleware(http.HandlerFunc(adminResetExperimentEventsHandler)))
@@ -67,7 +91,7 @@ func Run() {
 
 	log.Println("Go server is listening:")
 	log.Println("  - http://localhost:8088  (HTTP)")
-	log.Println("(API: /api/execute, /api/chat, /api/chat/ws, /api/grade, /api/memory, /api/summarize, /api/experiment-log, /api/lecture-views, /api/admin/profiles, /api/admin/events, /api/admin/task-progress, /api/admin/experiment-data, admin mutations)")
+	log.Println("(API: /api/execute, /api/execute/status/{id}, /api/execute/ws, /api/chat, /api/chat/ws, /api/chat/sessions, /api/grade, /api/grade/history, /api/tasks, /api/memory, /api/summarize, /api/experiment-log, /api/lecture-views, /api/admin/profiles, /api/admin/events, /api/admin/task-progress, /api/admin/grade-attempts, /api/admin/grade-overrides, /api/admin/similarity, /api/admin/experiment-data, admin mutations)")
 
 	if err := http.ListenAndServe(":8088", nil); err != nil {
 		log.Fatalf("server startup failed: %v", err)
@@ -0,0 +1,443 @@
+package app
+
+import (
+	"fmt"
+	"strings"
+)
+
+// 静的チェックの種類
+const (
+	StaticRuleRequire = "require" // 構文を使っていること
+	StaticRuleForbid  = "forbid"  // 構文を使っていないこと
+)
+
+// 静的チェックで判定できる構文
+const (
+	ConstructForLoop     = "for_loop"
+	ConstructWhileLoop   = "while_loop" // do-while も含む
+	ConstructDoWhile     = "do_while"
+	ConstructIf          = "if"
+	ConstructSwitch      = "switch"
+	ConstructFunction    = "function" // main 以外の関数定義
+	ConstructRecursion   = "recursion"
+	ConstructArray       = "array" // 組み込み配列の宣言（int a[10] など）
+	ConstructVector      = "vector"
+	ConstructStruct      = "struct" // struct または class
+	ConstructGoto        = "goto"
+	ConstructGlobalVar   = "global_variable"
+	ConstructPointer     = "pointer" // ポインタ型の宣言（int *p など）
+	ConstructRangeFor    = "range_for"
+	ConstructLambda      = "lambda"
+	ConstructIdentifier  = "identifier" // Identifier に指定した名前（sort, printf など）の使用
+	staticCheckMaxDetail = 5
+)
+
+var staticConstructs = map[string]bool{
+	ConstructForLoop: true, ConstructWhileLoop: true, ConstructDoWhile: true, ConstructIf: true,
+	ConstructSwitch: true, ConstructFunction: true, ConstructRecursion: true, ConstructArray: true,
+	ConstructVector: true, ConstructStruct: true, ConstructGoto: true, ConstructGlobalVar: true,
+	ConstructPointer: true, ConstructRangeFor: true, ConstructLambda: true, ConstructIdentifier: true,
+}
+
+// 変数宣言の型として扱う組み込み型のキーワード
+var cppTypeKeywords = map[string]bool{
+	"int": true, "char": true, "double": true, "float": true, "long": true, "short": true,
+	"bool": true, "unsigned": true, "signed": true, "auto": true, "string": true, "void": true,
+}
+
+// 関数呼び出しのように「名前 (」と続くが関数ではないキーワード
+var cppControlKeywords = map[string]bool{
+	"if": true, "for": true, "while": true, "switch": true, "catch": true, "return": true,
+	"sizeof": true, "alignof": true, "decltype": true, "static_assert": true, "do": true, "else": true,
+}
+
+// StaticCheck は課題ファイルの static_checks の1件。
+// 例: {"id": "use_for", "rule": "require", "construct": "for_loop", "description": "for 文で繰り返す"}
+type StaticCheck struct {
+	ID          string `json:"id"`
+	Rule        string `json:"rule"` // "require" or "forbid"
+	Construct   string `json:"construct"`
+	Identifier  string `json:"identifier,omitempty"` // construct=identifier のときの名前
+	Description string `json:"description,omitempty"`
+}
+
+// StaticFinding は静的チェック1件の判定結果
+type StaticFinding struct {
+	ID          string `json:"id"`
+	Rule        string `json:"rule"`
+	Construct   string `json:"construct"`
+	Description string `json:"description,omitempty"`
+	Found       int    `json:"found"`     // 構文が見つかった数
+	Satisfied   bool   `json:"satisfied"` // require なら見つかった、forbid なら見つからなかった
+	Detail      string `json:"detail,omitempty"`
+}
+
+// validateStaticChecks は課題ファイルの static_checks を検証します
+func validateStaticChecks(checks []StaticCheck) error {
+	seen := map[string]bool{}
+	for i, c := range checks {
+		if c.ID == "" || seen[c.ID] {
+			return fmt.Errorf("static_checks[%d]: id must be unique and non-empty", i)
+		}
+		seen[c.ID] = true
+		if c.Rule != StaticRuleRequire && c.Rule != StaticRuleForbid {
+			return fmt.Errorf("static check %s: unsupported rule %q", c.ID, c.Rule)
+		}
+		if !staticConstructs[c.Construct] {
+			return fmt.Errorf("static check %s: unsupported construct %q", c.ID, c.Construct)
+		}
+		if (c.Construct == ConstructIdentifier) != (c.Identifier != "") {
+			return fmt.Errorf("static check %s: identifier must be set only for construct %q", c.ID, ConstructIdentifier)
+		}
+	}
+	return nil
+}
+
+// runStaticChecks は提出コードを字句解析し、課題の静的チェックを判定します（チェックが無ければ nil）
+func runStaticChecks(code string, checks []StaticCheck) []StaticFinding {
+	if len(checks) == 0 {
+		return nil
+	}
+	analysis := analyzeCppSource(code)
+	findings := make([]StaticFinding, 0, len(checks))
+	for _, c := range checks {
+		names := analysis.constructs[c.Construct]
+		f := StaticFinding{ID: c.ID, Rule: c.Rule, Construct: c.Construct, Description: c.Description, Found: len(names)}
+		if c.Construct == ConstructIdentifier {
+			f.Found, f.Detail = analysis.identifiers[c.Identifier], c.Identifier
+		}
+		f.Satisfied = (c.Rule == StaticRuleRequire) == (f.Found > 0)
+		if details := uniqueNonEmpty(names); len(details) > 0 {
+			f.Detail = strings.Join(details[:min(len(details), staticCheckMaxDetail)], ", ")
+		}
+		findings = append(findings, f)
+	}
+	return findings
+}
+
+// formatStaticFindings は AI やチャットに渡すための静的チェックの結果を返します
+func formatStaticFindings(findings []StaticFinding) string {
+	var b strings.Builder
+	for _, f := range findings {
+		status := "OK"
+		if !f.Satisfied {
+			status = "NG"
+		}
+		target := f.Construct
+		if f.Detail != "" {
+			target += "（" + f.Detail + "）"
+		}
+		fmt.Fprintf(&b, "- [%s] %s %s: %s 検出数=%d\n", status, f.ID, f.Rule, target, f.Found)
+		if f.Description != "" {
+			fmt.Fprintf(&b, "  %s\n", f.Description)
+		}
+	}
+	return b.String()
+}
+
+// countSatisfiedByID は ids に含まれる静的チェック（空なら全件）の満たした数と対象数を返します
+func countSatisfiedByID(findings []StaticFinding, ids []string) (int, int) {
+	filter := map[string]bool{}
+	for _, id := range ids {
+		filter[id] = true
+	}
+	satisfied, n := 0, 0
+	for _, f := range findings {
+		if len(filter) > 0 && !filter[f.ID] {
+			continue
+		}
+		n++
+		if f.Satisfied {
+			satisfied++
+		}
+	}
+	return satisfied, n
+}
+
+// cppSourceAnalysis は字句解析で見つけた構文の一覧
+type cppSourceAnalysis struct {
+	constructs  map[string][]string // 構文 → 見つかった場所の名前（関数名など。無ければ空文字列）
+	identifiers map[string]int      // 識別子 → 出現回数
+}
+
+// cppFunctionDef は関数定義の名前と本体のトークン範囲（{ と } の位置）
+type cppFunctionDef struct {
+	Name       string
+	Open, Stop int
+}
+
+// analyzeCppSource はトークン列から構文を探します。
+// 構文解析はしないので、マクロや複雑なテンプレートでは誤判定することがある
+func analyzeCppSource(code string) cppSourceAnalysis {
+	tokens := lexCpp(code)
+	a := cppSourceAnalysis{constructs: map[string][]string{}, identifiers: map[string]int{}}
+	add := func(construct, name string) {
+		a.constructs[construct] = append(a.constructs[construct], name)
+	}
+
+	closing := matchBrackets(tokens)
+	opening := map[int]int{}
+	for open, end := range closing {
+		opening[end] = open
+	}
+	depth, parenDepth := 0, 0
+	for i, tok := range tokens {
+		next := tokenText(tokens, i+1)
+		prev := tokenText(tokens, i-1)
+		if tok.Kind == cppTokenOp {
+			switch tok.Text {
+			case "{":
+				depth++
+			case "}":
+				depth--
+			case "(":
+				parenDepth++
+			case ")":
+				parenDepth--
+			case "[":
+				// ラムダ式は「[...](」か「[...]{」で始まり、直前が値ではない
+				if end, ok := closing[i]; ok && (tokenText(tokens, end+1) == "(" || tokenText(tokens, end+1) == "{") &&
+					(prev == "" || prev == "=" || prev == "(" || prev == "," || prev == "return" || prev == "{" || prev == ";") {
+					add(ConstructLambda, "")
+				}
+			case "*":
+				// 型名の直後の * で、その後ろに名前が続くものをポインタの宣言とみなす
+				if cppTypeKeywords[prev] && tokenKind(tokens, i+1) == cppTokenIdent {
+					add(ConstructPointer, next)
+				}
+			}
+			continue
+		}
+		if tok.Kind != cppTokenIdent {
+			continue
+		}
+		a.identifiers[tok.Text]++
+		switch tok.Text {
+		case "for":
+			add(ConstructForLoop, "")
+			if end, ok := closing[i+1]; ok && next == "(" && hasTopLevelColon(tokens, i+2, end) {
+				add(ConstructRangeFor, "")
+			}
+		case "while":
+			add(ConstructWhileLoop, "")
+		case "do":
+			add(ConstructDoWhile, "")
+		case "if":
+			add(ConstructIf, "")
+		case "switch":
+			add(ConstructSwitch, "")
+		case "goto":
+			add(ConstructGoto, "")
+		case "vector":
+			add(ConstructVector, "")
+		case "struct", "class":
+			// enum class、テンプレート引数の class、前方宣言以外
+			if prev != "enum" && prev != "<" && prev != "," && tokenKind(tokens, i+1) == cppTokenIdent && tokenText(tokens, i+2) != ";" {
+				add(ConstructStruct, next)
+			}
+		default:
+			if next != "=" && next != ";" && next != "," && next != "[" {
+				break
+			}
+			specifiers := declarationSpecifiers(tokens, opening, i)
+			// 型 名前 [ を組み込み配列の宣言とみなす（a[i] などの添字アクセスは直前が型ではない）
+			if next == "[" && (specifiers != nil || tokenKind(tokens, i-1) == cppTokenIdent && !cppControlKeywords[prev] && prev != "delete" && prev != "new") {
+				add(ConstructArray, tok.Text)
+			}
+			// 関数の外で「型 名前 =」「型 名前 ;」なら大域変数。const / constexpr の定数は除く
+			if depth == 0 && parenDepth == 0 && specifiers != nil && !specifiers["const"] && !specifiers["constexpr"] {
+				add(ConstructGlobalVar, tok.Text)
+			}
+		}
+	}
+
+	for _, fn := range findFunctionDefs(tokens, closing) {
+		if fn.Name != "main" {
+			add(ConstructFunction, fn.Name)
+		}
+		for j := fn.Open + 1; j < fn.Stop; j++ {
+			if tokens[j].Kind == cppTokenIdent && tokens[j].Text == fn.Name && tokenText(tokens, j+1) == "(" &&
+				tokenText(tokens, j-1) != "." && tokenText(tokens, j-1) != "->" {
+				add(ConstructRecursion, fn.Name)
+				break
+			}
+		}
+	}
+	return a
+}
+
+// 宣言で型の前後に付く語（std::string の std と :: も含める）
+var cppDeclQualifiers = map[string]bool{
+	"const": true, "constexpr": true, "static": true, "inline": true, "volatile": true, "extern": true,
+	"thread_local": true, "mutable": true, "register": true, "std": true, "::": true,
+}
+
+// declarationSpecifiers は tokens[i] が「型 名前」か「型 a = 1, 名前」の形で宣言された名前なら、
+// 宣言の型と修飾子（const など）の集合を返します。宣言でなければ nil
+func declarationSpecifiers(tokens []cppToken, opening map[int]int, i int) map[string]bool {
+	j := i - 1
+	for tokenText(tokens, j) == "*" || tokenText(tokens, j) == "&" {
+		j--
+	}
+	if tokenText(tokens, j) == "," {
+		// 括弧や初期化子の { } を飛ばして文の先頭まで戻り、先頭から型を読む
+		for j--; j >= 0; j-- {
+			if open, ok := opening[j]; ok && (tokens[j].Text != "}" || tokenText(tokens, open-1) == "=" || tokenText(tokens, open-1) == ",") {
+				j = open
+				continue
+			}
+			if t := tokens[j].Text; t == ";" || t == "{" || t == "}" || t == "(" || t == "[" {
+				break
+			}
+		}
+		specifiers := map[string]bool{}
+		typed := false
+		for j++; j < i && (cppTypeKeywords[tokenText(tokens, j)] || cppDeclQualifiers[tokenText(tokens, j)]); j++ {
+			specifiers[tokens[j].Text] = true
+			typed = typed || cppTypeKeywords[tokens[j].Text]
+		}
+		for tokenText(tokens, j) == "*" || tokenText(tokens, j) == "&" {
+			j++
+		}
+		if !typed || tokenKind(tokens, j) != cppTokenIdent {
+			return nil
+		}
+		return specifiers
+	}
+	if !cppTypeKeywords[tokenText(tokens, j)] {
+		return nil
+	}
+	specifiers := map[string]bool{}
+	for ; j >= 0 && (cppTypeKeywords[tokens[j].Text] || cppDeclQualifiers[tokens[j].Text]); j-- {
+		specifiers[tokens[j].Text] = true
+	}
+	return specifiers
+}
+
+// findFunctionDefs は「名前 ( ... ) [const など] {」の形の関数定義を探します（クラスのメンバ関数も含む）
+func findFunctionDefs(tokens []cppToken, closing map[int]int) []cppFunctionDef {
+	var defs []cppFunctionDef
+	for i := 0; i+1 < len(tokens); i++ {
+		tok := tokens[i]
+		if tok.Kind != cppTokenIdent || cppControlKeywords[tok.Text] || tokens[i+1].Text != "(" {
+			continue
+		}
+		// ラムダ式や呼び出し式の中ではない（直前が演算子なら定義ではない。ただし :: と ~ は許す）
+		prev := tokenText(tokens, i-1)
+		if i > 0 && tokens[i-1].Kind == cppTokenOp && prev != "::" && prev != "~" && prev != "*" && prev != "&" && prev != ">" && prev != "{" && prev != "}" && prev != ";" && prev != ":" {
+			continue
+		}
+		// : の直後はアクセス指定子（public: など）の後のコンストラクタだけを定義とみなす。
+		// コンストラクタの初期化子リスト S(int v) : x(v) {} の x は関数定義ではない
+		if prev == ":" && !cppAccessSpecifiers[tokenText(tokens, i-2)] {
+			continue
+		}
+		end, ok := closing[i+1]
+		if !ok {
+			continue
+		}
+		j := skipFunctionQualifiers(tokens, end+1)
+		if tokenText(tokens, j) == ":" {
+			j = skipMemberInitializers(tokens, closing, j+1)
+		}
+		if tokenText(tokens, j) != "{" {
+			continue
+		}
+		stop, ok := closing[j]
+		if !ok {
+			continue
+		}
+		defs = append(defs, cppFunctionDef{Name: tok.Text, Open: j, Stop: stop})
+	}
+	return defs
+}
+
+var cppAccessSpecifiers = map[string]bool{"public": true, "protected": true, "private": true}
+
+// skipFunctionQualifiers は引数リストの後の const / noexcept などを読み飛ばした位置を返します
+func skipFunctionQualifiers(tokens []cppToken, j int) int {
+	for j < len(tokens) && (tokens[j].Text == "const" || tokens[j].Text == "noexcept" || tokens[j].Text == "override" || tokens[j].Text == "final") {
+		j++
+	}
+	return j
+}
+
+// skipMemberInitializers はコンストラクタの初期化子リスト（: の次の位置 j から）を読み飛ばし、本体の { の位置を返します。
+// 形が崩れていれば読み飛ばせたところまでの位置を返す
+func skipMemberInitializers(tokens []cppToken, closing map[int]int, j int) int {
+	for j < len(tokens) {
+		// メンバ名（基底クラスなら :: やテンプレート引数を含む）の後の ( または {
+		for j < len(tokens) && tokens[j].Text != "(" && tokens[j].Text != "{" && tokens[j].Text != ";" {
+			j++
+		}
+		end, ok := closing[j]
+		if !ok || tokenText(tokens, j) == ";" {
+			return j
+		}
+		j = end + 1
+		if tokenText(tokens, j) != "," {
+			return j
+		}
+		j++
+	}
+	return j
+}
+
+// matchBrackets は開き括弧（( [ {）の位置から対応する閉じ括弧の位置への対応を返します
+func matchBrackets(tokens []cppToken) map[int]int {
+	pairs := map[string]string{")": "(", "]": "[", "}": "{"}
+	closing := map[int]int{}
+	var stack []int
+	for i, tok := range tokens {
+		if tok.Kind != cppTokenOp {
+			continue
+		}
+		switch tok.Text {
+		case "(", "[", "{":
+			stack = append(stack, i)
+		case ")", "]", "}":
+			// 対応が崩れていたら（書きかけのコードなど）一致する開き括弧まで戻る
+			for len(stack) > 0 {
+				open := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				if tokens[open].Text == pairs[tok.Text] {
+					closing[open] = i
+					break
+				}
+			}
+		}
+	}
+	return closing
+}
+
+// hasTopLevelColon は tokens[start:end] に括弧の外の : があるか（範囲 for 文の判定用）
+func hasTopLevelColon(tokens []cppToken, start, end int) bool {
+	depth := 0
+	for _, tok := range tokens[start:end] {
+		switch tok.Text {
+		case "(", "[", "{":
+			depth++
+		case ")", "]", "}":
+			depth--
+		case ":":
+			if depth == 0 {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func tokenText(tokens []cppToken, i int) string {
+	if i < 0 || i >= len(tokens) {
+		return ""
+	}
+	return tokens[i].Text
+}
+
+func tokenKind(tokens []cppToken, i int) string {
+	if i < 0 || i >= len(tokens) {
+		return ""
+	}
+	return tokens[i].Kind
+}
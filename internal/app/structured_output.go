@@ -0,0 +1,241 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strings"
+	"unicode/utf8"
+)
+
+var errInvalidStructuredOutput = errors.New("AI output does not match the schema")
+
+// OutputSchema は AI の出力形式を表す JSON Schema。
+// Raw はプロバイダへそのまま送る（OpenAI は生成順がプロパティ順になるため、順序を保つ生の JSON で持つ）
+type OutputSchema struct {
+	Name string
+	Raw  json.RawMessage
+
+	parsed map[string]any
+}
+
+func mustOutputSchema(name, raw string) *OutputSchema {
+	s := &OutputSchema{Name: name, Raw: json.RawMessage(raw)}
+	if err := json.Unmarshal(s.Raw, &s.parsed); err != nil {
+		panic(fmt.Sprintf("invalid output schema %s: %v", name, err))
+	}
+	return s
+}
+
+// 立ち絵の表情ID（prompts/persona の表情カテゴリマッピングと format_stream.txt の aseri）
+var knownEmotionIDs = []string{"normal", "nico", "huhun", "doya", "tere", "melt", "komari", "aseri", "surprise", "oko", "iya", "akire", "sad"}
+
+const (
+	emotionParamMin = 0
+	emotionParamMax = 5
+	loveUpMin       = -3
+	loveUpMax       = 3
+	maxSummaryRunes = 400
+)
+
+const emotionParamsSchema = `{"type":"object","properties":{` +
+	`"joy":{"type":"integer","minimum":0,"maximum":5},` +
+	`"trust":{"type":"integer","minimum":0,"maximum":5},` +
+	`"fear":{"type":"integer","minimum":0,"maximum":5},` +
+	`"anger":{"type":"integer","minimum":0,"maximum":5},` +
+	`"shy":{"type":"integer","minimum":0,"maximum":5},` +
+	`"surprise":{"type":"integer","minimum":0,"maximum":5}},` +
+	`"required":["joy","trust","fear","anger","shy","surprise"],"additionalProperties":false}`
+
+func emotionEnumJSON() string {
+	b, _ := json.Marshal(knownEmotionIDs)
+	return string(b)
+}
+
+// chatThoughtSchema は /api/chat（thought モード）の ChatResponse の形式
+var chatThoughtSchema = mustOutputSchema("chat_response", `{"type":"object","properties":{`+
+	`"thought":{"type":"string"},`+
+	`"parameters":`+emotionParamsSchema+`,`+
+	`"text":{"type":"string"},`+
+	`"emotion":{"type":"string","enum":`+emotionEnumJSON()+`},`+
+	`"love_up":{"type":"integer","minimum":-3,"maximum":3}},`+
+	`"required":["thought","parameters","text","emotion","love_up"],"additionalProperties":false}`)
+
+// chatStreamSchema はストリーミング用。表示を早く始められるよう emotion と text を先に生成させる
+var chatStreamSchema = mustOutputSchema("chat_stream_response", `{"type":"object","properties":{`+
+	`"emotion":{"type":"string","enum":`+emotionEnumJSON()+`},`+
+	`"text":{"type":"string"},`+
+	`"parameters":`+emotionParamsSchema+`,`+
+	`"love_up":{"type":"integer","minimum":-3,"maximum":3}},`+
+	`"required":["emotion","text","parameters","love_up"],"additionalProperties":false}`)
+
+// chatHistorySummarySchema は古い会話をまとめた要約
+var chatHistorySummarySchema = mustOutputSchema("chat_history_summary", `{"type":"object","properties":{`+
+	`"summary":{"type":"string"}},`+
+	`"required":["summary"],"additionalProperties":false}`)
+
+var gradeResponseSchema = mustOutputSchema("grade_response", `{"type":"object","properties":{`+
+	`"criteria":{"type":"array","items":{"type":"object","properties":{`+
+	`"id":{"type":"string"},"score":{"type":"integer","minimum":0,"maximum":100},"comment":{"type":"string"}},`+
+	`"required":["id","score","comment"],"additionalProperties":false}},`+
+	`"reason":{"type":"string"},`+
+	`"improvement":{"type":"string"}},`+
+	`"required":["criteria","reason","improvement"],"additionalProperties":false}`)
+
+var summarySchema = mustOutputSchema("memory_summary", `{"type":"object","properties":{`+
+	`"summary":{"type":"string"},`+
+	`"learned_topics":{"type":"array","items":{"type":"string"}},`+
+	`"weaknesses":{"type":"array","items":{"type":"string"}},`+
+	`"last_updated":{"type":"string"}},`+
+	`"required":["summary","learned_topics","weaknesses","last_updated"],"additionalProperties":false}`)
+
+// Validate は値がスキーマの型・必須項目を満たすか検査し、問題点の一覧を返します。
+// 数値の範囲や enum の外れ値は修正依頼せず、各 normalize 関数で丸める
+func (s *OutputSchema) Validate(doc any) []string {
+	var problems []string
+	validateSchemaNode(s.parsed, doc, "$", &problems)
+	return problems
+}
+
+func validateSchemaNode(schema map[string]any, value any, path string, problems *[]string) {
+	switch schema["type"] {
+	case "object":
+		obj, ok := value.(map[string]any)
+		if !ok {
+			*problems = append(*problems, path+": must be an object")
+			return
+		}
+		if required, ok := schema["required"].([]any); ok {
+			for _, key := range required {
+				if _, exists := obj[key.(string)]; !exists {
+					*problems = append(*problems, fmt.Sprintf("%s.%s: is required", path, key))
+				}
+			}
+		}
+		props, _ := schema["properties"].(map[string]any)
+		for key, v := range obj {
+			if prop, ok := props[key].(map[string]any); ok {
+				validateSchemaNode(prop, v, path+"."+key, problems)
+			}
+		}
+	case "array":
+		arr, ok := value.([]any)
+		if !ok {
+			*problems = append(*problems, path+": must be an array")
+			return
+		}
+		if items, ok := schema["items"].(map[string]any); ok {
+			for i, v := range arr {
+				validateSchemaNode(items, v, fmt.Sprintf("%s[%d]", path, i), problems)
+			}
+		}
+	case "string":
+		if _, ok := value.(string); !ok {
+			*problems = append(*problems, path+": must be a string")
+		}
+	case "integer":
+		if n, ok := value.(float64); !ok || n != math.Trunc(n) {
+			*problems = append(*problems, path+": must be an integer")
+		}
+	}
+}
+
+// parseStructuredOutput は AI の出力をスキーマで検証してから out にデコードします
+func parseStructuredOutput(raw string, schema *OutputSchema, out any) error {
+	clean := cleanJSONString(raw)
+	var doc any
+	if err := json.Unmarshal([]byte(clean), &doc); err != nil {
+		return fmt.Errorf("%w: invalid JSON: %v", errInvalidStructuredOutput, err)
+	}
+	if problems := schema.Validate(doc); len(problems) > 0 {
+		return fmt.Errorf("%w: %s", errInvalidStructuredOutput, strings.Join(problems, "; "))
+	}
+	return json.Unmarshal([]byte(clean), out)
+}
+
+// repairStructuredOutput は不正な出力と問題点を AI に返し、スキーマに沿った JSON を1回だけ出し直させます
+func repairStructuredOutput(ctx context.Context, provider ChatProvider, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema, raw string, cause error, out any) error {
+	log.Printf("WARNING: %s output is invalid, requesting a repair: %v", schema.Name, cause)
+	repairMessages := append(append([]OpenAIMessage(nil), messages...),
+		OpenAIMessage{Role: "assistant", Content: raw},
+		OpenAIMessage{Role: "user", Content: fmt.Sprintf(
+			"The previous output was invalid (%v). Return the same answer again as a single JSON object that matches this JSON Schema exactly, with no other text:\n%s",
+			cause, string(schema.Raw),
+		)},
+	)
+	fixed, err := provider.GenerateJSON(ctx, systemPrompt, repairMessages, schema)
+	if err != nil {
+		return err
+	}
+	return parseStructuredOutput(fixed, schema, out)
+}
+
+// generateStructured はスキーマ付きで補完し、検証に失敗した場合は1回だけ修正を依頼します。
+// 戻り値の文字列は最初の生の出力（フォールバック表示用）
+func generateStructured(ctx context.Context, provider ChatProvider, systemPrompt string, messages []OpenAIMessage, schema *OutputSchema, out any) (string, error) {
+	raw, err := provider.GenerateJSON(ctx, systemPrompt, messages, schema)
+	if err != nil {
+		return "", err
+	}
+	if err := parseStructuredOutput(raw, schema, out); err != nil {
+		return raw, repairStructuredOutput(ctx, provider, systemPrompt, messages, schema, raw, err, out)
+	}
+	return raw, nil
+}
+
+// normalizeChatResponse は感情パラメータ・好感度変動を範囲内に丸め、未知の表情IDを normal にします
+func normalizeChatResponse(res *ChatResponse) {
+	p := &res.Parameters
+	for _, v := range []*int{&p.Joy, &p.Trust, &p.Fear, &p.Anger, &p.Shy, &p.Surprise} {
+		*v = min(max(*v, emotionParamMin), emotionParamMax)
+	}
+	res.LoveUp = min(max(res.LoveUp, loveUpMin), loveUpMax)
+	res.Emotion = strings.TrimSpace(res.Emotion)
+	if !isKnownEmotion(res.Emotion) {
+		if res.Emotion != "" {
+			log.Printf("WARNING: unknown emotion id %q, falling back to normal", res.Emotion)
+		}
+		res.Emotion = "normal"
+	}
+}
+
+func isKnownEmotion(id string) bool {
+	for _, known := range knownEmotionIDs {
+		if id == known {
+			return true
+		}
+	}
+	return false
+}
+
+// normalizeGradeResponse はスコアを 0..100 に丸めます（観点ごとの得点は scoreRubric で配点に丸める）
+func normalizeGradeResponse(res *GradeResponse) {
+	res.Score = min(max(res.Score, 0), 100)
+}
+
+// normalizeSummary は要約の長さを制限し、リストの空要素と重複を取り除きます
+func normalizeSummary(p *UserProfile) {
+	p.Summary = strings.TrimSpace(p.Summary)
+	if utf8.RuneCountInString(p.Summary) > maxSummaryRunes {
+		p.Summary = string([]rune(p.Summary)[:maxSummaryRunes])
+	}
+	p.LearnedTopics = uniqueNonEmpty(p.LearnedTopics)
+	p.Weaknesses = uniqueNonEmpty(p.Weaknesses)
+}
+
+func uniqueNonEmpty(items []string) []string {
+	seen := map[string]bool{}
+	out := []string{}
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if item == "" || seen[item] {
+			continue
+		}
+		seen[item] = true
+		out = append(out, item)
+	}
+	return out
+}
@@ -0,0 +1,31 @@
+package app
+
+import (
+	"net/http"
+)
+
+// tasksHandler は /api/tasks で課題の一覧を返します
+func tasksHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	tasks := getTaskRegistry().List()
+	summaries := make([]TaskSummary, 0, len(tasks))
+	for _, t := range tasks {
+		summaries = append(summaries, t.Summary())
+	}
+	writeJSON(w, summaries)
+}
+
+// taskDetailHandler は /api/tasks/{id} で課題の詳細（非公開テスト・模範解答を除く）を返します
+func taskDetailHandler(w http.ResponseWriter, r *http.Request) {
+	if !requireMethod(w, r, http.MethodGet) {
+		return
+	}
+	task := getTaskRegistry().Get(r.PathValue("id"))
+	if task == nil {
+		writeJSONError(w, http.StatusNotFound, "task not found")
+		return
+	}
+	writeJSON(w, task.Detail())
+}
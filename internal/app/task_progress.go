@@ -0,0 +1,123 @@
+package app
+
+import "fmt"
+
+// 課題をクリアして最高得点を更新したときに profiles.love_level に加算する好感度
+const gradeClearLoveBonus = 5
+
+// record_task_progress RPC の戻り値
+type TaskProgressUpdate struct {
+	IsNewRecord bool `json:"is_new_record"`
+	BonusLove   int  `json:"bonus_love"`
+	HighScore   int  `json:"high_score"`
+	IsCleared   bool `json:"is_cleared"`
+	LoveLevel   *int `json:"love_level"` // プロフィールが無い場合は null
+}
+
+// recordTaskProgress は採点結果を task_progress に反映し、好感度ボーナスを profiles に加算します。
+// 最高得点より高いときだけ更新する処理を DB 側の1トランザクションで行うため、同時に提出されても競合しない
+// （supabase/migrations/20261016020000_record_task_progress.sql）
+func recordTaskProgress(userID, taskID string, score int, passThreshold int, criteria []CriterionScore) (TaskProgressUpdate, error) {
+	var rows []TaskProgressUpdate
+	err := supabaseClient.DB.Rpc("record_task_progress", map[string]interface{}{
+		"p_user_id":        userID,
+		"p_task_id":        taskID,
+		"p_score":          score,
+		"p_pass_threshold": passThreshold,
+		"p_rubric_scores":  criteria,
+		"p_love_bonus":     gradeClearLoveBonus,
+	}).Execute(&rows)
+	if err != nil {
+		return TaskProgressUpdate{}, err
+	}
+	if len(rows) == 0 {
+		return TaskProgressUpdate{}, fmt.Errorf("record_task_progress returned no rows")
+	}
+	return rows[0], nil
+}
+
+// 教員による得点の変更の種類（grade_overrides.kind）
+const (
+	GradeChangeOverride      = "override"       // 教員が得点を直接指定（以降の提出では更新しない）
+	GradeChangeRegrade       = "regrade"        // 保存済みの提出を再採点
+	GradeChangeManualUpdate  = "manual_update"  // /api/admin/task-progress/update による変更（上書きの状態は変えない）
+	GradeChangeClearOverride = "clear_override" // 教員の上書きを解除
+)
+
+// GradeOverrideRecord は教員による得点変更の監査記録（grade_overrides テーブル）
+type GradeOverrideRecord struct {
+	ID            int64  `json:"id"`
+	CreatedAt     string `json:"created_at"`
+	UserID        string `json:"user_id"`
+	TaskID        string `json:"task_id"`
+	Kind          string `json:"kind"`
+	AttemptID     *int64 `json:"attempt_id"`     // 再採点した提出
+	AIScore       *int   `json:"ai_score"`       // AI 採点での最高得点（上書き前の本来の得点）
+	PreviousScore *int   `json:"previous_score"` // 変更前の high_score
+	NewScore      int    `json:"new_score"`
+	IsCleared     bool   `json:"is_cleared"`
+	Reason        string `json:"reason"`
+	ChangedBy     string `json:"changed_by"`
+}
+
+// GradeChange は adminSetTaskProgress に渡す変更内容
+type GradeChange struct {
+	UserID          string
+	TaskID          string
+	Score           int
+	IsCleared       bool
+	RubricScores    []CriterionScore // nil なら現在の内訳を残す
+	Kind            string
+	Reason          string
+	ChangedBy       string
+	AttemptID       *int64
+	RespectOverride bool // true なら教員が上書きした得点は変更しない
+}
+
+// adminSetTaskProgress は task_progress の得点を設定し、変更を grade_overrides に記録します。
+// 記録と更新は DB 側の1トランザクションで行う（supabase/migrations/20261016050000_grade_overrides.sql）。
+// RespectOverride で教員の上書きを尊重して変更しなかった場合は false を返す
+func adminSetTaskProgress(change GradeChange) (GradeOverrideRecord, bool, error) {
+	var rows []GradeOverrideRecord
+	params := map[string]interface{}{
+		"p_user_id":          change.UserID,
+		"p_task_id":          change.TaskID,
+		"p_score":            change.Score,
+		"p_is_cleared":       change.IsCleared,
+		"p_rubric_scores":    nil,
+		"p_kind":             change.Kind,
+		"p_reason":           change.Reason,
+		"p_changed_by":       change.ChangedBy,
+		"p_attempt_id":       change.AttemptID,
+		"p_respect_override": change.RespectOverride,
+	}
+	if change.RubricScores != nil {
+		params["p_rubric_scores"] = change.RubricScores
+	}
+	if err := supabaseClient.DB.Rpc("admin_set_task_progress", params).Execute(&rows); err != nil {
+		return GradeOverrideRecord{}, false, err
+	}
+	if len(rows) == 0 {
+		return GradeOverrideRecord{}, false, nil
+	}
+	return rows[0], true, nil
+}
+
+// adminClearTaskProgressOverride は教員の上書きを解除し、以降の提出の得点が反映されるようにします。
+// 得点はそのまま残す。上書きされていなかった場合は false を返す
+func adminClearTaskProgressOverride(userID, taskID, reason, changedBy string) (GradeOverrideRecord, bool, error) {
+	var rows []GradeOverrideRecord
+	params := map[string]interface{}{
+		"p_user_id":    userID,
+		"p_task_id":    taskID,
+		"p_reason":     reason,
+		"p_changed_by": changedBy,
+	}
+	if err := supabaseClient.DB.Rpc("admin_clear_task_progress_override", params).Execute(&rows); err != nil {
+		return GradeOverrideRecord{}, false, err
+	}
+	if len(rows) == 0 {
+		return GradeOverrideRecord{}, false, nil
+	}
+	return rows[0], true, nil
+}
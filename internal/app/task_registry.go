@@ -0,0 +1,356 @@
+package app
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const defaultPassThreshold = 80
+
+var taskIDRegex = regexp.MustCompile(`^[A-Za-z0-9_\-]+$`)
+
+// 課題ファイルとして読み込む拡張子。YAML もフィールド名は JSON と同じ
+var taskFileExts = map[string]bool{".json": true, ".yaml": true, ".yml": true}
+
+// Task は TASKS_DIR/<task_id>.json（または .yaml / .yml）に置く課題定義。
+// Tests と ReferenceSolution は採点専用で、クライアントには返さない
+type Task struct {
+	ID                string            `json:"id"`
+	Title             string            `json:"title"`
+	Description       string            `json:"description"`
+	ExpectedOutput    string            `json:"expected_output,omitempty"` // 課題文に載せる出力例
+	SampleTests       []TestCase        `json:"sample_tests,omitempty"`    // 公開するテストケース
+	Tests             []TestCase        `json:"tests,omitempty"`           // 非公開テストケース
+	TimeLimitMs       int               `json:"time_limit_ms,omitempty"`
+	ReferenceSolution string            `json:"reference_solution,omitempty"`
+	RandomTests       *RandomTestSpec   `json:"random_tests,omitempty"`  // 模範解答と出力を比べるランダム入力
+	StaticChecks      []StaticCheck     `json:"static_checks,omitempty"` // 使うべき・使ってはいけない構文
+	Rubric            []RubricCriterion `json:"rubric,omitempty"`
+	PassThreshold     int               `json:"pass_threshold,omitempty"` // クリアとみなす点数（デフォルト 80）
+}
+
+// 採点ルーブリックの観点（1件分）。Points が観点の重み（全観点の合計を 100 点に換算する）
+type RubricCriterion struct {
+	ID          string   `json:"id"`
+	Description string   `json:"description"`
+	Points      int      `json:"points"`
+	Kind        string   `json:"kind,omitempty"`   // "tests", "static" or "ai"（デフォルト）
+	Tests       []string `json:"tests,omitempty"`  // kind=tests で対象にするテストケース名（"random" でランダム入力のケース）。空なら全ケース
+	Checks      []string `json:"checks,omitempty"` // kind=static で対象にする静的チェックの ID。空なら全チェック
+}
+
+// /api/tasks の一覧の要素
+type TaskSummary struct {
+	ID    string `json:"id"`
+	Title string `json:"title"`
+}
+
+// /api/tasks/{id} のレスポンス（非公開の情報を除いたもの）
+type TaskDetail struct {
+	ID             string            `json:"id"`
+	Title          string            `json:"title"`
+	Description    string            `json:"description"`
+	ExpectedOutput string            `json:"expected_output,omitempty"`
+	SampleTests    []TestCase        `json:"sample_tests,omitempty"`
+	TimeLimitMs    int               `json:"time_limit_ms,omitempty"`
+	StaticChecks   []StaticCheck     `json:"static_checks,omitempty"`
+	Rubric         []RubricCriterion `json:"rubric,omitempty"`
+	PassThreshold  int               `json:"pass_threshold"`
+}
+
+var (
+	taskRegistryInstance *TaskRegistry
+	taskRegistryOnce     sync.Once
+)
+
+// TaskRegistry は課題定義ファイルを読み込んで保持します。
+// 参照時に一定間隔でファイルの更新を確認し、変更があれば読み直す（再起動なしで課題を追加・修正できる）
+type TaskRegistry struct {
+	Dir            string
+	ReloadInterval time.Duration
+
+	mu        sync.RWMutex
+	tasks     map[string]*Task
+	stamps    map[string]string // ファイル名 → 更新時刻とサイズ
+	checkedAt time.Time
+}
+
+func getTaskRegistry() *TaskRegistry {
+	taskRegistryOnce.Do(func() {
+		taskRegistryInstance = &TaskRegistry{
+			Dir:            tasksDir(),
+			ReloadInterval: time.Duration(positiveIntEnv("TASKS_RELOAD_SEC", 5)) * time.Second,
+			tasks:          map[string]*Task{},
+		}
+		taskRegistryInstance.reload()
+	})
+	return taskRegistryInstance
+}
+
+func tasksDir() string {
+	if dir := strings.TrimSpace(os.Getenv("TASKS_DIR")); dir != "" {
+		return dir
+	}
+	return "./tasks"
+}
+
+// Get は ID に対応する課題を返します。存在しなければ nil
+func (r *TaskRegistry) Get(id string) *Task {
+	r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.tasks[id]
+}
+
+// List は課題を ID 順で返します
+func (r *TaskRegistry) List() []*Task {
+	r.refresh()
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	tasks := make([]*Task, 0, len(r.tasks))
+	for _, t := range r.tasks {
+		tasks = append(tasks, t)
+	}
+	sort.Slice(tasks, func(i, j int) bool { return tasks[i].ID < tasks[j].ID })
+	return tasks
+}
+
+// refresh は前回の確認から ReloadInterval 以上経っていれば、ファイルの変更を確認して読み直します
+func (r *TaskRegistry) refresh() {
+	r.mu.RLock()
+	due := time.Since(r.checkedAt) >= r.ReloadInterval
+	r.mu.RUnlock()
+	if due {
+		r.reload()
+	}
+}
+
+func (r *TaskRegistry) reload() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkedAt = time.Now()
+
+	stamps, err := taskFileStamps(r.Dir)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("ERROR: task registry scan failed: dir=%s err=%v", r.Dir, err)
+		}
+		return
+	}
+	if maps.Equal(stamps, r.stamps) {
+		return
+	}
+
+	names := slices.Sorted(maps.Keys(stamps))
+	tasks := make(map[string]*Task, len(stamps))
+	for _, name := range names {
+		task, err := loadTaskFile(filepath.Join(r.Dir, name))
+		if err != nil {
+			// 壊れたファイルは前回読み込めた内容を使い続ける
+			log.Printf("ERROR: task file load failed: %v", err)
+			if prev, ok := r.tasks[taskFileStem(name)]; ok {
+				tasks[prev.ID] = prev
+			}
+			continue
+		}
+		if _, dup := tasks[task.ID]; dup {
+			log.Printf("ERROR: task file load failed: %s: task %q is already defined by another file", name, task.ID)
+			continue
+		}
+		tasks[task.ID] = task
+	}
+	r.tasks = tasks
+	r.stamps = stamps
+	log.Printf("INFO: loaded %d tasks from %s", len(tasks), r.Dir)
+}
+
+func taskFileStamps(dir string) (map[string]string, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	stamps := map[string]string{}
+	for _, e := range entries {
+		if e.IsDir() || !taskFileExts[filepath.Ext(e.Name())] {
+			continue
+		}
+		info, err := e.Info()
+		if err != nil {
+			continue
+		}
+		stamps[e.Name()] = fmt.Sprintf("%d:%d", info.ModTime().UnixNano(), info.Size())
+	}
+	return stamps, nil
+}
+
+func taskFileStem(name string) string {
+	return strings.TrimSuffix(filepath.Base(name), filepath.Ext(name))
+}
+
+// loadTaskFile は課題ファイルを読み込みます。ID はファイル名（拡張子なし）と一致している必要がある
+func loadTaskFile(path string) (*Task, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if ext := filepath.Ext(path); ext == ".yaml" || ext == ".yml" {
+		if content, err = yamlToJSON(content); err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+	}
+	var task Task
+	if err := json.Unmarshal(content, &task); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	stem := taskFileStem(path)
+	if task.ID == "" {
+		task.ID = stem
+	}
+	if task.ID != stem || !taskIDRegex.MatchString(task.ID) {
+		return nil, fmt.Errorf("%s: id %q must match the file name", path, task.ID)
+	}
+	if task.PassThreshold <= 0 || task.PassThreshold > 100 {
+		task.PassThreshold = defaultPassThreshold
+	}
+	if len(task.Tests) > maxJudgeCases {
+		return nil, fmt.Errorf("%s: too many tests (max %d)", path, maxJudgeCases)
+	}
+	testNames := map[string]bool{}
+	for _, tc := range task.GradingTests() {
+		if tc.Name != "" {
+			testNames[tc.Name] = true
+		}
+	}
+	if task.RandomTests != nil {
+		testNames[randomTestsRubricName] = true
+	}
+	if err := validateStaticChecks(task.StaticChecks); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	checkIDs := map[string]bool{}
+	for _, c := range task.StaticChecks {
+		checkIDs[c.ID] = true
+	}
+	if err := validateRubric(task.Rubric, testNames, checkIDs); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if task.RandomTests != nil {
+		if strings.TrimSpace(task.ReferenceSolution) == "" {
+			return nil, fmt.Errorf("%s: random_tests requires reference_solution", path)
+		}
+		if _, err := generateRandomInputs(*task.RandomTests); err != nil {
+			return nil, fmt.Errorf("%s: random_tests: %w", path, err)
+		}
+	}
+	return &task, nil
+}
+
+// YAML で文字列として読むフィールド。expected_output: 3 のようにクォートしない数値なども書いたとおりの文字列にする。
+// 配列（tests / checks など）では要素の値に適用する
+var taskYAMLStringFields = map[string]bool{
+	"id": true, "title": true, "description": true, "name": true, "input": true, "expected_output": true,
+	"reference_solution": true, "rule": true, "construct": true, "identifier": true, "kind": true,
+	"tests": true, "sample_tests": true, "checks": true,
+}
+
+// yamlToJSON は YAML を JSON に変換します。課題定義の構造体は JSON のタグだけで定義する
+func yamlToJSON(content []byte) ([]byte, error) {
+	var doc yaml.Node
+	if err := yaml.Unmarshal(content, &doc); err != nil {
+		return nil, err
+	}
+	if len(doc.Content) == 0 {
+		return nil, fmt.Errorf("empty YAML document")
+	}
+	value, err := yamlNodeValue(&doc, false)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(value)
+}
+
+// yamlNodeValue は YAML のノードを JSON に変換できる値にします。asString ならスカラーは書かれた文字列のまま返す
+func yamlNodeValue(n *yaml.Node, asString bool) (interface{}, error) {
+	switch n.Kind {
+	case yaml.DocumentNode:
+		return yamlNodeValue(n.Content[0], asString)
+	case yaml.AliasNode:
+		return yamlNodeValue(n.Alias, asString)
+	case yaml.MappingNode:
+		m := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			key := n.Content[i].Value
+			v, err := yamlNodeValue(n.Content[i+1], taskYAMLStringFields[key])
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+		}
+		return m, nil
+	case yaml.SequenceNode:
+		list := make([]interface{}, len(n.Content))
+		for i, item := range n.Content {
+			v, err := yamlNodeValue(item, asString)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	default:
+		if asString && n.Tag != "!!null" {
+			return n.Value, nil
+		}
+		var v interface{}
+		if err := n.Decode(&v); err != nil {
+			return nil, fmt.Errorf("line %d: %w", n.Line, err)
+		}
+		return v, nil
+	}
+}
+
+// GradingRubric は採点に使うルーブリックを返します。未設定ならテストとスタイルの2観点
+func (t *Task) GradingRubric() []RubricCriterion {
+	if len(t.Rubric) > 0 {
+		return t.Rubric
+	}
+	return defaultRubric(gradeStylePoints())
+}
+
+// GradingTests は採点に使うテストケースを返します。非公開テストが無ければ公開テストを使う
+func (t *Task) GradingTests() []TestCase {
+	if len(t.Tests) > 0 {
+		return t.Tests
+	}
+	return t.SampleTests
+}
+
+func (t *Task) Summary() TaskSummary {
+	return TaskSummary{ID: t.ID, Title: t.Title}
+}
+
+func (t *Task) Detail() TaskDetail {
+	return TaskDetail{
+		ID:             t.ID,
+		Title:          t.Title,
+		Description:    t.Description,
+		ExpectedOutput: t.ExpectedOutput,
+		SampleTests:    t.SampleTests,
+		TimeLimitMs:    t.TimeLimitMs,
+		StaticChecks:   t.StaticChecks,
+		Rubric:         t.Rubric,
+		PassThreshold:  t.PassThreshold,
+	}
+}
@@ -0,0 +1,3 @@
+-- 最高得点を記録したときのルーブリック観点ごとの内訳
+alter table public.task_progress
+  add column if not exists rubric_scores jsonb not null default '[]'::jsonb;
@@ -0,0 +1,27 @@
+-- 採点1回ごとの記録（task_progress は最高得点のみのため、提出の推移はこちらで追う）
+create table if not exists public.grade_attempts (
+  id bigint generated always as identity primary key,
+  created_at timestamptz not null default now(),
+  user_id uuid not null references public.profiles (id) on delete cascade,
+  task_id text not null,
+  code text not null default '',
+  output text not null default '',
+  judge_result text not null default '',
+  verdict text not null default '',
+  passed integer not null default 0,
+  total integer not null default 0,
+  cases jsonb not null default '[]'::jsonb,
+  score integer not null default 0,
+  is_cleared boolean not null default false,
+  rubric_scores jsonb not null default '[]'::jsonb,
+  reason text not null default '',
+  improvement text not null default '',
+  model text not null default '',
+  ai_latency_ms bigint not null default 0,
+  total_latency_ms bigint not null default 0
+);
+
+create index if not exists grade_attempts_user_task_created_idx
+  on public.grade_attempts (user_id, task_id, created_at desc);
+
+alter table public.grade_attempts enable row level security;
@@ -0,0 +1,69 @@
+-- 採点結果の task_progress への反映と好感度ボーナスの付与を1トランザクションで行う。
+-- 同時に提出されても行が重複せず、高い得点が低い得点で上書きされない
+
+-- 既存の重複行は最高得点の行だけを残す
+delete from public.task_progress t
+using public.task_progress d
+where t.user_id = d.user_id
+  and t.task_id = d.task_id
+  and (t.high_score < d.high_score or (t.high_score = d.high_score and t.ctid < d.ctid));
+
+create unique index if not exists task_progress_user_task_key
+  on public.task_progress (user_id, task_id);
+
+create or replace function public.record_task_progress(
+  p_user_id uuid,
+  p_task_id text,
+  p_score integer,
+  p_pass_threshold integer,
+  p_rubric_scores jsonb,
+  p_love_bonus integer
+)
+returns table (
+  is_new_record boolean,
+  bonus_love integer,
+  high_score integer,
+  is_cleared boolean,
+  love_level integer
+)
+language plpgsql
+security definer
+set search_path = public
+as $$
+#variable_conflict use_column
+declare
+  v_inserted boolean;
+  v_written boolean := false;
+begin
+  insert into task_progress as tp (user_id, task_id, high_score, is_cleared, rubric_scores)
+  values (p_user_id, p_task_id, p_score, p_score >= p_pass_threshold, coalesce(p_rubric_scores, '[]'::jsonb))
+  on conflict (user_id, task_id) do update
+    set high_score = excluded.high_score,
+        is_cleared = tp.is_cleared or excluded.is_cleared,
+        rubric_scores = excluded.rubric_scores
+    where excluded.high_score > tp.high_score
+  returning (xmax = 0) into v_inserted;
+  v_written := found;
+
+  -- 初回の提出は記録更新として扱わない（ボーナスはクリアしていれば付与する）
+  is_new_record := v_written and not v_inserted;
+  bonus_love := case when v_written and p_score >= p_pass_threshold then p_love_bonus else 0 end;
+
+  if bonus_love > 0 then
+    update profiles p
+      set love_level = coalesce(p.love_level, 0) + bonus_love
+      where p.id = p_user_id
+    returning p.love_level into love_level;
+  else
+    select p.love_level into love_level from profiles p where p.id = p_user_id;
+  end if;
+
+  select tp.high_score, tp.is_cleared into high_score, is_cleared
+    from task_progress tp
+    where tp.user_id = p_user_id and tp.task_id = p_task_id;
+
+  return next;
+end;
+$$;
+
+revoke all on function public.record_task_progress(uuid, text, integer, integer, jsonb, integer) from public, anon, authenticated;
@@ -0,0 +1,9 @@
+-- 複数サンプルによる合議採点の記録
+alter table public.grade_attempts
+  add column if not exists sample_scores jsonb not null default '[]'::jsonb,
+  add column if not exists score_spread integer not null default 0,
+  add column if not exists needs_review boolean not null default false;
+
+create index if not exists grade_attempts_needs_review_idx
+  on public.grade_attempts (created_at desc)
+  where needs_review;
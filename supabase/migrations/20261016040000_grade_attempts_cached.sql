@@ -0,0 +1,3 @@
+-- 採点キャッシュから返した提出かどうか
+alter table public.grade_attempts
+  add column if not exists cached boolean not null default false;
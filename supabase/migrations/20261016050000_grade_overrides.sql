@@ -0,0 +1,157 @@
+-- 教員による得点の上書き・再採点と、その監査記録
+
+alter table public.task_progress
+  add column if not exists overridden boolean not null default false;
+
+alter table public.grade_attempts
+  add column if not exists regrade_of bigint references public.grade_attempts (id) on delete set null;
+
+create table if not exists public.grade_overrides (
+  id bigint generated always as identity primary key,
+  created_at timestamptz not null default now(),
+  user_id uuid not null references public.profiles (id) on delete cascade,
+  task_id text not null,
+  kind text not null check (kind in ('override', 'regrade', 'manual_update')),
+  attempt_id bigint references public.grade_attempts (id) on delete set null,
+  ai_score integer,       -- AI 採点での最高得点（上書き前の本来の得点）
+  previous_score integer, -- 変更前の task_progress.high_score
+  new_score integer not null,
+  is_cleared boolean not null,
+  reason text not null default '',
+  changed_by text not null default ''
+);
+
+create index if not exists grade_overrides_user_task_idx
+  on public.grade_overrides (user_id, task_id, created_at desc);
+
+alter table public.grade_overrides enable row level security;
+
+-- 教員が上書きした得点は、以降の提出（record_task_progress）で更新しない
+create or replace function public.record_task_progress(
+  p_user_id uuid,
+  p_task_id text,
+  p_score integer,
+  p_pass_threshold integer,
+  p_rubric_scores jsonb,
+  p_love_bonus integer
+)
+returns table (
+  is_new_record boolean,
+  bonus_love integer,
+  high_score integer,
+  is_cleared boolean,
+  love_level integer
+)
+language plpgsql
+security definer
+set search_path = public
+as $$
+#variable_conflict use_column
+declare
+  v_inserted boolean;
+  v_written boolean := false;
+begin
+  insert into task_progress as tp (user_id, task_id, high_score, is_cleared, rubric_scores)
+  values (p_user_id, p_task_id, p_score, p_score >= p_pass_threshold, coalesce(p_rubric_scores, '[]'::jsonb))
+  on conflict (user_id, task_id) do update
+    set high_score = excluded.high_score,
+        is_cleared = tp.is_cleared or excluded.is_cleared,
+        rubric_scores = excluded.rubric_scores
+    where excluded.high_score > tp.high_score
+      and not tp.overridden
+  returning (xmax = 0) into v_inserted;
+  v_written := found;
+
+  -- 初回の提出は記録更新として扱わない（ボーナスはクリアしていれば付与する）
+  is_new_record := v_written and not v_inserted;
+  bonus_love := case when v_written and p_score >= p_pass_threshold then p_love_bonus else 0 end;
+
+  if bonus_love > 0 then
+    update profiles p
+      set love_level = coalesce(p.love_level, 0) + bonus_love
+      where p.id = p_user_id
+    returning p.love_level into love_level;
+  else
+    select p.love_level into love_level from profiles p where p.id = p_user_id;
+  end if;
+
+  select tp.high_score, tp.is_cleared into high_score, is_cleared
+    from task_progress tp
+    where tp.user_id = p_user_id and tp.task_id = p_task_id;
+
+  return next;
+end;
+$$;
+
+revoke all on function public.record_task_progress(uuid, text, integer, integer, jsonb, integer) from public, anon, authenticated;
+
+-- task_progress の得点を教員の操作として設定し、grade_overrides に記録する。
+-- p_respect_override が true（再採点）のときは、教員が上書きした得点を変更せず、行を返さない
+create or replace function public.admin_set_task_progress(
+  p_user_id uuid,
+  p_task_id text,
+  p_score integer,
+  p_is_cleared boolean,
+  p_rubric_scores jsonb,
+  p_kind text,
+  p_reason text,
+  p_changed_by text,
+  p_attempt_id bigint,
+  p_respect_override boolean
+)
+returns table (
+  id bigint,
+  created_at timestamptz,
+  user_id uuid,
+  task_id text,
+  kind text,
+  attempt_id bigint,
+  ai_score integer,
+  previous_score integer,
+  new_score integer,
+  is_cleared boolean,
+  reason text,
+  changed_by text
+)
+language plpgsql
+security definer
+set search_path = public
+as $$
+#variable_conflict use_column
+declare
+  v_previous integer;
+  v_overridden boolean;
+  v_ai_score integer;
+  v_audit grade_overrides%rowtype;
+begin
+  select tp.high_score, tp.overridden into v_previous, v_overridden
+    from task_progress tp
+    where tp.user_id = p_user_id and tp.task_id = p_task_id
+    for update;
+
+  if p_respect_override and coalesce(v_overridden, false) then
+    return;
+  end if;
+
+  insert into task_progress as tp (user_id, task_id, high_score, is_cleared, rubric_scores, overridden)
+  values (p_user_id, p_task_id, p_score, p_is_cleared, coalesce(p_rubric_scores, '[]'::jsonb), p_kind <> 'regrade')
+  on conflict (user_id, task_id) do update
+    set high_score = excluded.high_score,
+        is_cleared = excluded.is_cleared,
+        rubric_scores = coalesce(p_rubric_scores, tp.rubric_scores),
+        overridden = excluded.overridden;
+
+  select max(ga.score) into v_ai_score
+    from grade_attempts ga
+    where ga.user_id = p_user_id and ga.task_id = p_task_id and ga.regrade_of is null;
+
+  insert into grade_overrides (user_id, task_id, kind, attempt_id, ai_score, previous_score, new_score, is_cleared, reason, changed_by)
+  values (p_user_id, p_task_id, p_kind, p_attempt_id, v_ai_score, v_previous, p_score, p_is_cleared, coalesce(p_reason, ''), coalesce(p_changed_by, ''))
+  returning * into v_audit;
+
+  return query select v_audit.id, v_audit.created_at, v_audit.user_id, v_audit.task_id, v_audit.kind, v_audit.attempt_id,
+    v_audit.ai_score, v_audit.previous_score, v_audit.new_score, v_audit.is_cleared, v_audit.reason, v_audit.changed_by;
+end;
+$$;
+
+revoke all on function public.admin_set_task_progress(uuid, text, integer, boolean, jsonb, text, text, text, bigint, boolean) from public, anon, authenticated;
@@ -0,0 +1,3 @@
+-- 模範解答との比較で見つかった反例（ランダム入力で最初に出力が食い違ったもの）
+alter table public.grade_attempts
+  add column if not exists counterexample jsonb;
@@ -0,0 +1,3 @@
+-- 課題の静的チェック（使うべき・使ってはいけない構文）の判定結果
+alter table public.grade_attempts
+  add column if not exists static_checks jsonb;
@@ -0,0 +1,37 @@
+-- キャラクターとの会話のセッション（再接続・再読み込み後も続きから話せるようサーバー側に保存する）
+create table if not exists public.chat_sessions (
+  id uuid primary key default gen_random_uuid(),
+  created_at timestamptz not null default now(),
+  updated_at timestamptz not null default now(),
+  user_id uuid not null references public.profiles (id) on delete cascade,
+  character_id text not null default '',
+  task_id text not null default '',
+  title text not null default '',
+  emotion text not null default '',
+  parameters jsonb,
+  love_level integer not null default 0
+);
+
+create index if not exists chat_sessions_user_updated_idx
+  on public.chat_sessions (user_id, updated_at desc);
+
+alter table public.chat_sessions enable row level security;
+
+-- セッション内のメッセージ（user の発言時のコードも残す）
+create table if not exists public.chat_messages (
+  id bigint generated always as identity primary key,
+  created_at timestamptz not null default now(),
+  session_id uuid not null references public.chat_sessions (id) on delete cascade,
+  role text not null check (role in ('user', 'assistant')),
+  content text not null default '',
+  code text not null default '',
+  task_id text not null default '',
+  emotion text not null default '',
+  parameters jsonb,
+  love_up integer not null default 0
+);
+
+create index if not exists chat_messages_session_id_idx
+  on public.chat_messages (session_id, id desc);
+
+alter table public.chat_messages enable row level security;
@@ -0,0 +1,4 @@
+-- 古いやり取りの要約（直近のやり取りだけをそのまま AI に渡し、それより前は要約にまとめる）
+alter table public.chat_sessions
+  add column if not exists summary text not null default '',
+  add column if not exists summarized_until bigint not null default 0;
@@ -0,0 +1,4 @@
+-- 課題レジストリのテストケースで採点したか。false はクライアントが送った想定出力・実行結果に基づく採点で、
+-- GRADE_REQUIRE_VERIFIED=true のときだけ task_progress に反映しない（既存の記録は判別できないため false とする）
+alter table public.grade_attempts
+  add column if not exists verified boolean not null default false;
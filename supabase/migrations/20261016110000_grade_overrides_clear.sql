@@ -0,0 +1,140 @@
+-- task_progress.overridden は教員が得点を直接指定した場合（kind = 'override'）だけ立てる。
+-- 通常の得点編集（manual_update）では現在の状態を保ち、再採点（regrade）で反映した場合は外す。
+-- 上書きを解除して以降の提出の得点を再び反映させる clear_override を追加する
+
+alter table public.grade_overrides
+  drop constraint if exists grade_overrides_kind_check;
+
+alter table public.grade_overrides
+  add constraint grade_overrides_kind_check
+  check (kind in ('override', 'regrade', 'manual_update', 'clear_override'));
+
+create or replace function public.admin_set_task_progress(
+  p_user_id uuid,
+  p_task_id text,
+  p_score integer,
+  p_is_cleared boolean,
+  p_rubric_scores jsonb,
+  p_kind text,
+  p_reason text,
+  p_changed_by text,
+  p_attempt_id bigint,
+  p_respect_override boolean
+)
+returns table (
+  id bigint,
+  created_at timestamptz,
+  user_id uuid,
+  task_id text,
+  kind text,
+  attempt_id bigint,
+  ai_score integer,
+  previous_score integer,
+  new_score integer,
+  is_cleared boolean,
+  reason text,
+  changed_by text
+)
+language plpgsql
+security definer
+set search_path = public
+as $$
+#variable_conflict use_column
+declare
+  v_previous integer;
+  v_overridden boolean;
+  v_ai_score integer;
+  v_audit grade_overrides%rowtype;
+begin
+  select tp.high_score, tp.overridden into v_previous, v_overridden
+    from task_progress tp
+    where tp.user_id = p_user_id and tp.task_id = p_task_id
+    for update;
+
+  if p_respect_override and coalesce(v_overridden, false) then
+    return;
+  end if;
+
+  insert into task_progress as tp (user_id, task_id, high_score, is_cleared, rubric_scores, overridden)
+  values (p_user_id, p_task_id, p_score, p_is_cleared, coalesce(p_rubric_scores, '[]'::jsonb), p_kind = 'override')
+  on conflict (user_id, task_id) do update
+    set high_score = excluded.high_score,
+        is_cleared = excluded.is_cleared,
+        rubric_scores = coalesce(p_rubric_scores, tp.rubric_scores),
+        overridden = case p_kind
+          when 'override' then true
+          when 'regrade' then false
+          else tp.overridden
+        end;
+
+  select max(ga.score) into v_ai_score
+    from grade_attempts ga
+    where ga.user_id = p_user_id and ga.task_id = p_task_id and ga.regrade_of is null;
+
+  insert into grade_overrides (user_id, task_id, kind, attempt_id, ai_score, previous_score, new_score, is_cleared, reason, changed_by)
+  values (p_user_id, p_task_id, p_kind, p_attempt_id, v_ai_score, v_previous, p_score, p_is_cleared, coalesce(p_reason, ''), coalesce(p_changed_by, ''))
+  returning * into v_audit;
+
+  return query select v_audit.id, v_audit.created_at, v_audit.user_id, v_audit.task_id, v_audit.kind, v_audit.attempt_id,
+    v_audit.ai_score, v_audit.previous_score, v_audit.new_score, v_audit.is_cleared, v_audit.reason, v_audit.changed_by;
+end;
+$$;
+
+revoke all on function public.admin_set_task_progress(uuid, text, integer, boolean, jsonb, text, text, text, bigint, boolean) from public, anon, authenticated;
+
+-- 教員の上書きを解除する。得点はそのまま残し、以降の提出で上回れば更新されるようにする。
+-- 上書きされていなければ何もせず、行を返さない
+create or replace function public.admin_clear_task_progress_override(
+  p_user_id uuid,
+  p_task_id text,
+  p_reason text,
+  p_changed_by text
+)
+returns table (
+  id bigint,
+  created_at timestamptz,
+  user_id uuid,
+  task_id text,
+  kind text,
+  attempt_id bigint,
+  ai_score integer,
+  previous_score integer,
+  new_score integer,
+  is_cleared boolean,
+  reason text,
+  changed_by text
+)
+language plpgsql
+security definer
+set search_path = public
+as $$
+#variable_conflict use_column
+declare
+  v_score integer;
+  v_is_cleared boolean;
+  v_ai_score integer;
+  v_audit grade_overrides%rowtype;
+begin
+  update task_progress tp
+    set overridden = false
+    where tp.user_id = p_user_id and tp.task_id = p_task_id and tp.overridden
+  returning tp.high_score, tp.is_cleared into v_score, v_is_cleared;
+
+  if not found then
+    return;
+  end if;
+
+  select max(ga.score) into v_ai_score
+    from grade_attempts ga
+    where ga.user_id = p_user_id and ga.task_id = p_task_id and ga.regrade_of is null;
+
+  insert into grade_overrides (user_id, task_id, kind, ai_score, previous_score, new_score, is_cleared, reason, changed_by)
+  values (p_user_id, p_task_id, 'clear_override', v_ai_score, v_score, v_score, v_is_cleared, coalesce(p_reason, ''), coalesce(p_changed_by, ''))
+  returning * into v_audit;
+
+  return query select v_audit.id, v_audit.created_at, v_audit.user_id, v_audit.task_id, v_audit.kind, v_audit.attempt_id,
+    v_audit.ai_score, v_audit.previous_score, v_audit.new_score, v_audit.is_cleared, v_audit.reason, v_audit.changed_by;
+end;
+$$;
+
+revoke all on function public.admin_clear_task_progress_override(uuid, text, text, text) from public, anon, authenticated;